@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+const (
+	maxAPIKeysPerUser = 20
+
+	// rate limits are requests per apiKeyRatePeriod
+	apiKeyRatePeriod       = time.Minute
+	defaultAPIKeyRateLimit = 60
+	maxAPIKeyRateLimit     = 600
+
+	// last-used is only written this often, not on every request
+	apiKeyTouchInterval = time.Minute
+)
+
+// APIKey is a personal API key. Key is only returned when it's created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int32      `json:"rate_limit"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func apiKeyFromDB(k database.ApiKey) APIKey {
+	key := APIKey{
+		ID:         k.ID,
+		CreatedAt:  k.CreatedAt,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		RateLimit:  k.RateLimit,
+		LastUsedIP: k.LastUsedIp,
+	}
+	if k.ExpiresAt.Valid {
+		key.ExpiresAt = &k.ExpiresAt.Time
+	}
+	if k.LastUsedAt.Valid {
+		key.LastUsedAt = &k.LastUsedAt.Time
+	}
+	return key
+}
+
+type apiKeyContextKey struct{}
+
+// requestAPIKey is the personal API key middlewareAPIKeys accepted for r.
+func requestAPIKey(r *http.Request) (database.ApiKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey{}).(database.ApiKey)
+	return key, ok
+}
+
+// middlewareAPIKeys looks up a personal API key sent as "Authorization:
+// ApiKey chirpy_..." once per request, enforces its rate limit and passes
+// it on in the request context for authorize. Other ApiKey values, such as
+// the Polka key, go through untouched.
+func (cfg *apiConfig) middlewareAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := auth.GetAPIKey(r.Header)
+		if err != nil || !strings.HasPrefix(raw, auth.APIKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := cfg.queries.GetAPIKeyByHash(r.Context(), auth.HashToken(raw))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("couldn't look up api key: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if err != nil || key.RevokedAt.Valid || (key.ExpiresAt.Valid && key.ExpiresAt.Time.Before(time.Now())) {
+			w.Header().Set("WWW-Authenticate", `ApiKey realm="chirpy"`)
+			respondWithError(w, 401, "Invalid, expired or revoked API key")
+			return
+		}
+
+		res := cfg.apiKeyLimiter.Allow(key.ID.String(), int(key.RateLimit), time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(res.Reset.Unix(), 10))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(res.Reset).Seconds()))))
+			respondWithError(w, 429, "Rate limit exceeded for this API key")
+			return
+		}
+
+		if !key.LastUsedAt.Valid || time.Since(key.LastUsedAt.Time) > apiKeyTouchInterval {
+			err = cfg.queries.TouchAPIKey(r.Context(), database.TouchAPIKeyParams{
+				ID:         key.ID,
+				LastUsedIp: clientIP(r),
+			})
+			if err != nil {
+				log.Printf("couldn't record api key use: %s", err)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+func (cfg *apiConfig) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		RateLimit *int32     `json:"rate_limit"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		respondWithError(w, 400, "Key name must be 1 to 100 characters")
+		return
+	}
+	scopes, err := auth.ParseScopes(strings.Join(params.Scopes, " "))
+	if err != nil {
+		respondWithError(w, 400, "Invalid scopes: "+err.Error())
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if params.ExpiresAt != nil {
+		if !params.ExpiresAt.After(time.Now()) {
+			respondWithError(w, 400, "expires_at must be in the future")
+			return
+		}
+		expiresAt = sql.NullTime{Time: *params.ExpiresAt, Valid: true}
+	}
+	rateLimit := int32(defaultAPIKeyRateLimit)
+	if params.RateLimit != nil {
+		if *params.RateLimit < 1 || *params.RateLimit > maxAPIKeyRateLimit {
+			respondWithError(w, 400, "rate_limit must be between 1 and 600 requests a minute")
+			return
+		}
+		rateLimit = *params.RateLimit
+	}
+
+	count, err := cfg.queries.CountAPIKeys(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't count api keys: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		respondWithError(w, 400, "Too many API keys")
+		return
+	}
+
+	raw, err := auth.MakeAPIKey()
+	if err != nil {
+		log.Printf("couldn't make api key: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	key, err := cfg.queries.CreateAPIKey(context.Background(), database.CreateAPIKeyParams{
+		UserID:    caller,
+		Name:      params.Name,
+		Prefix:    raw[:len(auth.APIKeyPrefix)+6],
+		KeyHash:   auth.HashToken(raw),
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("couldn't create api key: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	created := apiKeyFromDB(key)
+	created.Key = raw
+	respondWithBody(w, 201, created)
+}
+
+func (cfg *apiConfig) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	rows, err := cfg.queries.ListAPIKeys(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list api keys: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	keys := []APIKey{}
+	for _, k := range rows {
+		keys = append(keys, apiKeyFromDB(k))
+	}
+	respondWithBody(w, 200, keys)
+}
+
+func (cfg *apiConfig) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid key id")
+		return
+	}
+
+	n, err := cfg.queries.RevokeAPIKey(context.Background(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: caller,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke api key: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "No API key with that id")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/stream"
+)
+
+type Relation struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// relationTarget authenticates the caller and resolves the {userID} path
+// value of a block, mute or follow request. It writes the error response
+// itself and returns ok == false when the request should not go any further.
+func (cfg *apiConfig) relationTarget(w http.ResponseWriter, r *http.Request) (caller uuid.UUID, target database.User, ok bool) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return uuid.Nil, database.User{}, false
+	}
+
+	targetID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return uuid.Nil, database.User{}, false
+	}
+	if targetID == caller {
+		respondWithError(w, 400, "You cannot do that to yourself")
+		return uuid.Nil, database.User{}, false
+	}
+
+	target, err = cfg.queries.GetUserByID(context.Background(), targetID)
+	if err != nil {
+		log.Printf("couldn't find user %s: %s", targetID, err)
+		w.WriteHeader(404)
+		return uuid.Nil, database.User{}, false
+	}
+	return caller, target, true
+}
+
+// authorFilter decides whose chirp events a viewer may see in a live
+// stream. It holds only the viewer's own relations, so it stays small
+// however many users there are; private authors are recognised from the
+// event itself. Queries filter the same way in SQL.
+type authorFilter struct {
+	viewer    uuid.UUID
+	hidden    map[uuid.UUID]bool
+	following map[uuid.UUID]bool
+}
+
+// loadAuthorFilter loads viewer's blocks (either way), mutes and follows.
+// Pass uuid.Nil for anonymous viewers, who see no private authors.
+func (cfg *apiConfig) loadAuthorFilter(ctx context.Context, viewer uuid.UUID) (authorFilter, error) {
+	f := authorFilter{viewer: viewer, hidden: map[uuid.UUID]bool{}, following: map[uuid.UUID]bool{}}
+	if viewer == uuid.Nil {
+		return f, nil
+	}
+	hidden, err := cfg.queries.ListHiddenUserIDs(ctx, viewer)
+	if err != nil {
+		return f, err
+	}
+	for _, id := range hidden {
+		f.hidden[id] = true
+	}
+	followees, err := cfg.queries.ListFolloweeIDs(ctx, viewer)
+	if err != nil {
+		return f, err
+	}
+	for _, id := range followees {
+		f.following[id] = true
+	}
+	return f, nil
+}
+
+// allows reports whether the viewer may see e.
+func (f authorFilter) allows(e stream.Event) bool {
+	if f.hidden[e.AuthorID] {
+		return false
+	}
+	return !e.AuthorPrivate || e.AuthorID == f.viewer || f.following[e.AuthorID]
+}
+
+func (cfg *apiConfig) blockUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// the block and the follows it ends go together, so a failure part way
+	// can't leave a block with the follow still in place
+	ctx := context.Background()
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("couldn't start transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	err = qtx.CreateBlock(ctx, database.CreateBlockParams{
+		BlockerID: caller,
+		BlockedID: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't block user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// a block ends any follow relationship in both directions
+	between := database.DeleteFollowsBetweenParams{UserA: caller, UserB: target.ID}
+	err = qtx.DeleteFollowsBetween(ctx, between)
+	if err != nil {
+		log.Printf("couldn't remove follows: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = qtx.DeleteFollowRequestsBetween(ctx, database.DeleteFollowRequestsBetweenParams(between))
+	if err != nil {
+		log.Printf("couldn't remove follow requests: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("couldn't commit block: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unblockUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.DeleteBlock(context.Background(), database.DeleteBlockParams{
+		BlockerID: caller,
+		BlockedID: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't unblock user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) muteUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.CreateMute(context.Background(), database.CreateMuteParams{
+		MuterID: caller,
+		MutedID: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't mute user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unmuteUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.DeleteMute(context.Background(), database.DeleteMuteParams{
+		MuterID: caller,
+		MutedID: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't unmute user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) listBlocks(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	blocks, err := cfg.queries.ListBlocks(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list blocks: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	relations := []Relation{}
+	for _, b := range blocks {
+		relations = append(relations, Relation{UserID: b.BlockedID, CreatedAt: b.CreatedAt})
+	}
+	respondWithBody(w, 200, relations)
+}
+
+func (cfg *apiConfig) listMutes(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	mutes, err := cfg.queries.ListMutes(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list mutes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	relations := []Relation{}
+	for _, m := range mutes {
+		relations = append(relations, Relation{UserID: m.MutedID, CreatedAt: m.CreatedAt})
+	}
+	respondWithBody(w, 200, relations)
+}
@@ -0,0 +1,86 @@
+// Command argon2-bench suggests Argon2id parameters for password hashing
+// that take about -target on this machine. Run it where the server runs:
+//
+//	go run ./cmd/argon2-bench -target 250ms -max-memory 256
+//
+// Memory is raised first, since it's what makes guessing expensive on
+// dedicated hardware, then iterations make up any remaining time. The
+// output is ready to paste into the server's environment.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/tnaums/chirpy/internal/auth"
+)
+
+// minMemory is the least memory suggested, in KiB, however slow the machine.
+const minMemory = 16 * 1024
+
+func main() {
+	target := flag.Duration("target", 500*time.Millisecond, "time one hash should take")
+	maxMemory := flag.Uint("max-memory", 1024, "most memory a hash may use, in MiB")
+	parallelism := flag.Uint("parallelism", uint(min(runtime.NumCPU(), 255)), "threads per hash")
+	saltLength := flag.Uint("salt", 16, "salt length in bytes")
+	runs := flag.Int("runs", 3, "hashes timed per measurement")
+	flag.Parse()
+
+	params := auth.PasswordParams()
+	params.Iterations = 1
+	params.Parallelism = uint8(*parallelism)
+	params.SaltLength = uint32(*saltLength)
+	params.Memory = min(params.Memory, uint32(*maxMemory)*1024)
+	if err := auth.SetPasswordParams(params); err != nil {
+		log.Fatal(err)
+	}
+
+	took := measure(params, *runs)
+	// a slow machine gives up memory before going under minMemory
+	halved := false
+	for took > *target && params.Memory/2 >= minMemory {
+		params.Memory /= 2
+		took = measure(params, *runs)
+		halved = true
+	}
+	for !halved && took < *target && params.Memory*2 <= uint32(*maxMemory)*1024 {
+		next := params
+		next.Memory *= 2
+		t := measure(next, *runs)
+		if t > *target {
+			break
+		}
+		params, took = next, t
+	}
+	if took < *target {
+		params.Iterations = max(1, uint32(*target/took))
+		took = measure(params, *runs)
+	}
+
+	fmt.Printf("m=%d MiB, t=%d, p=%d: %s per hash\n\n", params.Memory/1024, params.Iterations, params.Parallelism, took.Round(time.Millisecond))
+	fmt.Printf("ARGON2_MEMORY=%d\n", params.Memory)
+	fmt.Printf("ARGON2_ITERATIONS=%d\n", params.Iterations)
+	fmt.Printf("ARGON2_PARALLELISM=%d\n", params.Parallelism)
+	fmt.Printf("ARGON2_SALT_LENGTH=%d\n", params.SaltLength)
+}
+
+// measure is the median time of a few hashes with params.
+func measure(params argon2id.Params, runs int) time.Duration {
+	times := make([]time.Duration, max(runs, 1))
+	for i := range times {
+		start := time.Now()
+		if _, err := argon2id.CreateHash("correct horse battery staple", &params); err != nil {
+			log.Fatal(err)
+		}
+		times[i] = time.Since(start)
+	}
+	slices.Sort(times)
+	t := times[len(times)/2]
+	log.Printf("m=%d MiB, t=%d: %s", params.Memory/1024, params.Iterations, t.Round(time.Millisecond))
+	return t
+}
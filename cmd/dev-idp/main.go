@@ -0,0 +1,43 @@
+// Command dev-idp is a local OpenID Connect provider for developing
+// sign-in with a provider. It signs everyone in as the user given by its
+// flags without asking for a password.
+// Point Chirpy at it with:
+//
+//	go run ./cmd/dev-idp -email jane@example.com
+//	OIDC_PROVIDER=dev OIDC_ISSUER=http://localhost:8091 \
+//	    OIDC_CLIENT_ID=chirpy OIDC_CLIENT_SECRET=dev-secret go run .
+//
+// then open http://localhost:8080/api/oidc/dev/login in a browser.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/tnaums/chirpy/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8091", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:8091", "issuer URL, as Chirpy reaches it")
+	clientID := flag.String("client-id", "chirpy", "client ID Chirpy uses")
+	clientSecret := flag.String("client-secret", "dev-secret", "client secret Chirpy uses")
+	email := flag.String("email", "jane@example.com", "email of the user to sign in")
+	subject := flag.String("sub", "", "subject of the user to sign in (defaults to one derived from -email)")
+	unverified := flag.Bool("unverified", false, "say the email isn't verified")
+	flag.Parse()
+
+	if *subject == "" {
+		*subject = "sub-" + *email
+	}
+	idp := oidctest.New(*issuer, *clientID, *clientSecret)
+	idp.SetUser(oidctest.User{
+		Subject:       *subject,
+		Email:         *email,
+		EmailVerified: !*unverified,
+	})
+
+	log.Printf("identity provider %s signing in %s", *issuer, *email)
+	log.Fatal(http.ListenAndServe(*addr, idp.Handler()))
+}
@@ -0,0 +1,54 @@
+// Command webhook-receiver is a local endpoint for developing against
+// Chirpy's outbound webhooks. It verifies each delivery's signature and
+// logs the event. Register it with PLATFORM=dev, which allows http URLs:
+//
+//	go run ./cmd/webhook-receiver -secret whsec_...
+//	curl -X POST localhost:8080/api/webhooks -H "Authorization: Bearer $TOKEN" \
+//	    -d '{"url": "http://localhost:8090/", "events": ["chirp.created"]}'
+//
+// Use -status to answer with an error code and watch the retries.
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tnaums/chirpy/internal/webhook"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	secret := flag.String("secret", os.Getenv("WEBHOOK_SECRET"), "endpoint signing secret")
+	status := flag.Int("status", 200, "status code to respond with")
+	flag.Parse()
+
+	if *secret == "" {
+		log.Fatal("a signing secret is required: pass -secret or set WEBHOOK_SECRET")
+	}
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("couldn't read body: %s", err)
+			w.WriteHeader(400)
+			return
+		}
+
+		err = webhook.Verify(*secret, r.Header.Get(webhook.SignatureHeader), body, 5*time.Minute, time.Now())
+		if err != nil {
+			log.Printf("rejected delivery %s: %s", r.Header.Get("Chirpy-Delivery"), err)
+			w.WriteHeader(401)
+			return
+		}
+
+		log.Printf("%s delivery %s: %s", r.Header.Get("Chirpy-Event"), r.Header.Get("Chirpy-Delivery"), body)
+		w.WriteHeader(*status)
+	})
+
+	log.Printf("listening for webhooks on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
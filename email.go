@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+	chirpymail "github.com/tnaums/chirpy/internal/mail"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+
+	// resends are limited per user: one a minute and a few an hour
+	verificationCooldown  = time.Minute
+	maxVerificationsPerHr = 5
+)
+
+// mailerFromEnv picks the Mailer named by MAILER: "smtp", "file" or "log".
+// Development defaults to logging messages.
+func mailerFromEnv() (chirpymail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@chirpy.local>"
+	}
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, errors.New("SMTP_ADDR is required for the smtp mailer")
+		}
+		return chirpymail.SMTPMailer{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return chirpymail.FileMailer{Dir: dir, From: from}, nil
+	case "", "log":
+		return chirpymail.LogMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+}
+
+// validateEmail accepts a bare address such as walt@example.com.
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("Invalid email address")
+	}
+	return nil
+}
+
+// sendVerificationEmail emails user a single-use link confirming their
+// current address. Only a hash of the link's token is stored.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeSignedToken(user.ID, auth.TokenTypeEmailVerification, cfg.secretPhrase, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	err = cfg.queries.CreateEmailVerification(ctx, database.CreateEmailVerificationParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.baseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, chirpymail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: "Welcome to Chirpy!\n\n" +
+			"Confirm your email address by opening this link within 24 hours:\n\n" +
+			link + "\n\n" +
+			"If you didn't sign up for Chirpy, you can ignore this email.\n",
+	})
+}
+
+// emailVerified reports whether userID may use features gated on a
+// verified email. It's always true unless REQUIRE_VERIFIED_EMAIL is set.
+func (cfg *apiConfig) emailVerified(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if !cfg.requireVerifiedEmail {
+		return true, nil
+	}
+	user, err := cfg.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerifiedAt.Valid, nil
+}
+
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	userID, err := auth.ValidateSignedToken(token, auth.TokenTypeEmailVerification, cfg.secretPhrase)
+	if err != nil {
+		respondWithError(w, 400, "Invalid or expired verification link")
+		return
+	}
+
+	verification, err := cfg.queries.GetEmailVerification(context.Background(), auth.HashToken(token))
+	if err != nil || verification.UserID != userID {
+		respondWithError(w, 400, "Invalid or expired verification link")
+		return
+	}
+	n, err := cfg.queries.UseEmailVerification(context.Background(), verification.TokenHash)
+	if err != nil {
+		log.Printf("couldn't use email verification: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 400, "Verification link has already been used")
+		return
+	}
+
+	// a link sent before the user changed their email must not verify the new one
+	user, err := cfg.queries.GetUserByID(context.Background(), userID)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if user.Email != verification.Email {
+		respondWithError(w, 400, "Verification link is for a different email address")
+		return
+	}
+	_, err = cfg.queries.MarkEmailVerified(context.Background(), database.MarkEmailVerifiedParams{
+		ID:    userID,
+		Email: verification.Email,
+	})
+	if err != nil {
+		log.Printf("couldn't mark email verified: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	respondWithBody(w, 200, response{Email: user.Email, EmailVerified: true})
+}
+
+func (cfg *apiConfig) resendVerification(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, 409, "Email is already verified")
+		return
+	}
+
+	recent, err := cfg.queries.CountEmailVerificationsSince(context.Background(), database.CountEmailVerificationsSinceParams{
+		UserID:    caller,
+		CreatedAt: time.Now().Add(-verificationCooldown),
+	})
+	if err != nil {
+		log.Printf("couldn't count email verifications: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	hourly, err := cfg.queries.CountEmailVerificationsSince(context.Background(), database.CountEmailVerificationsSinceParams{
+		UserID:    caller,
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		log.Printf("couldn't count email verifications: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if recent > 0 || hourly >= maxVerificationsPerHr {
+		retry := verificationCooldown
+		if hourly >= maxVerificationsPerHr {
+			retry = time.Hour
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+		respondWithError(w, 429, "Too many verification emails, try again later")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(context.Background(), user)
+	if err != nil {
+		log.Printf("couldn't send verification email: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(202)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+// followedEvent is the user.followed webhook payload, sent to the followee.
+type followedEvent struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	FolloweeID uuid.UUID `json:"followee_id"`
+}
+
+func (cfg *apiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	blocked, err := cfg.queries.IsBlockedEitherWay(context.Background(), database.IsBlockedEitherWayParams{
+		UserA: caller,
+		UserB: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't check blocks: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if blocked {
+		respondWithError(w, 403, "You cannot follow this user")
+		return
+	}
+
+	type response struct {
+		Status string `json:"status"`
+	}
+
+	// private accounts have to approve their followers
+	if target.IsPrivate {
+		err = cfg.queries.CreateFollowRequest(context.Background(), database.CreateFollowRequestParams{
+			RequesterID: caller,
+			TargetID:    target.ID,
+		})
+		if err != nil {
+			log.Printf("couldn't create follow request: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		cfg.notify(context.Background(), target.ID, caller, NotificationFollowRequest, uuid.Nil)
+		respondWithBody(w, 202, response{Status: "requested"})
+		return
+	}
+
+	err = cfg.queries.CreateFollow(context.Background(), database.CreateFollowParams{
+		FollowerID: caller,
+		FolloweeID: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't follow user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	cfg.notify(context.Background(), target.ID, caller, NotificationFollow, uuid.Nil)
+	cfg.enqueueWebhook(context.Background(), target.ID, WebhookUserFollowed, followedEvent{
+		FollowerID: caller,
+		FolloweeID: target.ID,
+	})
+	respondWithBody(w, 200, response{Status: "following"})
+}
+
+// unfollowUser also withdraws a pending follow request.
+func (cfg *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.DeleteFollow(context.Background(), database.DeleteFollowParams{
+		FollowerID: caller,
+		FolloweeID: target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't unfollow user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	_, err = cfg.queries.DeleteFollowRequest(context.Background(), database.DeleteFollowRequestParams{
+		RequesterID: caller,
+		TargetID:    target.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't withdraw follow request: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) listFollowRequests(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	users, err := cfg.queries.ListFollowRequests(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list follow requests: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	profiles := []Profile{}
+	for _, u := range users {
+		profiles = append(profiles, profileFromUser(u))
+	}
+	respondWithBody(w, 200, profiles)
+}
+
+func (cfg *apiConfig) acceptFollowRequest(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	requester, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+
+	n, err := cfg.queries.AcceptFollowRequest(context.Background(), database.AcceptFollowRequestParams{
+		RequesterID: requester,
+		TargetID:    caller,
+	})
+	if err != nil {
+		log.Printf("couldn't accept follow request: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "No pending follow request from that user")
+		return
+	}
+	cfg.enqueueWebhook(context.Background(), caller, WebhookUserFollowed, followedEvent{
+		FollowerID: requester,
+		FolloweeID: caller,
+	})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) rejectFollowRequest(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	requester, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+
+	n, err := cfg.queries.DeleteFollowRequest(context.Background(), database.DeleteFollowRequestParams{
+		RequesterID: requester,
+		TargetID:    caller,
+	})
+	if err != nil {
+		log.Printf("couldn't reject follow request: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "No pending follow request from that user")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -1,13 +1,36 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"fmt"
+	"strings"
 )
+
+// APIKeyPrefix starts every key users mint, which tells them apart from
+// the Polka and admin keys and lets secret scanners spot leaked ones.
+const APIKeyPrefix = "chirpy_"
+
+// GetAPIKey returns the key from an "Authorization: ApiKey <key>" header.
 func GetAPIKey(headers http.Header) (string, error) {
 	token := headers.Get("Authorization")
 	if token == "" {
 		return "", fmt.Errorf("no token found")
 	}
-	return token[7:], nil
+	scheme, key, found := strings.Cut(token, " ")
+	if !found || !strings.EqualFold(scheme, "ApiKey") || key == "" {
+		return "", fmt.Errorf("authorization scheme isn't ApiKey")
+	}
+	return key, nil
+}
+
+// MakeAPIKey returns a random key for a user. Like refresh tokens, only
+// its HashToken is stored.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(key), nil
 }
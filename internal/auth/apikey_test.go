@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetAPIKey(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "ApiKey f271c81ff7084ee5b99a5091b42d486e")
+	key, err := GetAPIKey(headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertCorrectMessage(t, key, "f271c81ff7084ee5b99a5091b42d486e")
+
+	for _, bad := range []string{"", "ApiKey", "ApiKey ", "Bearer f271c81ff7084ee5b99a5091b42d486e", "abc"} {
+		headers.Set("Authorization", bad)
+		if _, err := GetAPIKey(headers); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestMakeAPIKey(t *testing.T) {
+	a, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, _ := MakeAPIKey()
+	if !strings.HasPrefix(a, APIKeyPrefix) || len(a) != len(APIKeyPrefix)+64 {
+		t.Errorf("unexpected key format %q", a)
+	}
+	assertDifferent(t, a, b)
+}
@@ -1,11 +1,57 @@
 package auth
 
 import (
+	"errors"
+	"sync"
 	"github.com/alexedwards/argon2id"
 )
 
+// passwordParams are what new password hashes are made with. They're set
+// once at startup, before any hashing.
+var passwordParams = *argon2id.DefaultParams
+
+// PasswordParams returns the Argon2id parameters new hashes are made with.
+func PasswordParams() argon2id.Params {
+	return passwordParams
+}
+
+// SetPasswordParams changes the parameters for new hashes. Hashes made
+// with weaker ones keep working, and NeedsRehash reports them.
+func SetPasswordParams(p argon2id.Params) error {
+	switch {
+	case p.Iterations < 1:
+		return errors.New("argon2id needs at least one iteration")
+	case p.Parallelism < 1:
+		return errors.New("argon2id needs a parallelism of at least one")
+	case p.Memory < 8*uint32(p.Parallelism):
+		return errors.New("argon2id needs at least 8 KiB of memory per thread")
+	case p.SaltLength < 8:
+		return errors.New("argon2id salts must be at least 8 bytes")
+	case p.KeyLength < 16:
+		return errors.New("argon2id keys must be at least 16 bytes")
+	}
+	passwordParams = p
+	return nil
+}
+
+// NeedsRehash reports whether hash was made with parameters weaker than the
+// current ones, so it should be replaced the next time the password is
+// known. Parallelism doesn't count: it changes how long a hash takes, not
+// how much work guessing it is.
+func NeedsRehash(hash string) bool {
+	p, salt, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return false
+	}
+	return p.Memory < passwordParams.Memory ||
+		p.Iterations < passwordParams.Iterations ||
+		uint32(len(salt)) < passwordParams.SaltLength ||
+		p.KeyLength < passwordParams.KeyLength
+}
+
 func HashPassword(password string) (string, error){
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	params := passwordParams
+	hash, err := argon2id.CreateHash(password, &params)
 	if err != nil {
 		return "", err
 	}
@@ -21,3 +67,25 @@ func CheckPasswordHash(password, hash string) (bool, error){
 
 	return match, nil
 }
+
+// dummyHash stands in for a missing password hash.
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := HashPassword("chirpy dummy password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// ComparePassword reports whether password matches hash. An empty hash,
+// for an unknown email or a passwordless account, never matches but is
+// checked against a dummy hash anyway, so the time taken doesn't tell
+// anyone whether the account exists.
+func ComparePassword(password, hash string) bool {
+	if hash == "" {
+		CheckPasswordHash(password, dummyHash())
+		return false
+	}
+	match, err := CheckPasswordHash(password, hash)
+	return err == nil && match
+}
@@ -3,6 +3,8 @@ package auth
 import (
 	"testing"
 	//	"fmt"
+
+	"github.com/alexedwards/argon2id"
 )
 
 func TestPassword(t *testing.T) {
@@ -12,3 +14,70 @@ func TestPassword(t *testing.T) {
 		t.Errorf("expected 'true' but got %t", got)
 	}
 }
+
+func TestComparePassword(t *testing.T) {
+	hash, _ := HashPassword("pa$$word")
+	if !ComparePassword("pa$$word", hash) {
+		t.Errorf("expected the right password to match")
+	}
+	if ComparePassword("wrong", hash) {
+		t.Errorf("expected a wrong password not to match")
+	}
+	// unknown emails and passwordless accounts have no hash
+	if ComparePassword("", "") || ComparePassword("pa$$word", "") {
+		t.Errorf("expected an empty hash never to match")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defaults := PasswordParams()
+	defer SetPasswordParams(defaults)
+
+	weak := defaults
+	weak.Memory = 16 * 1024
+	if err := SetPasswordParams(weak); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	old, _ := HashPassword("pa$$word")
+	if NeedsRehash(old) {
+		t.Errorf("expected a hash with the current parameters to be kept")
+	}
+
+	SetPasswordParams(defaults)
+	if !NeedsRehash(old) {
+		t.Errorf("expected a hash with less memory to need rehashing")
+	}
+	if !ComparePassword("pa$$word", old) {
+		t.Errorf("expected a hash with old parameters to still match")
+	}
+	current, _ := HashPassword("pa$$word")
+	if NeedsRehash(current) {
+		t.Errorf("expected a fresh hash to be kept")
+	}
+
+	fewer := defaults
+	fewer.Parallelism = 1
+	SetPasswordParams(fewer)
+	if NeedsRehash(current) {
+		t.Errorf("expected a change of parallelism alone to be ignored")
+	}
+}
+
+func TestSetPasswordParamsInvalid(t *testing.T) {
+	defaults := PasswordParams()
+	defer SetPasswordParams(defaults)
+
+	for _, p := range []argon2id.Params{
+		{Memory: 64 * 1024, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		{Memory: 64 * 1024, Iterations: 1, Parallelism: 0, SaltLength: 16, KeyLength: 32},
+		{Memory: 8, Iterations: 1, Parallelism: 4, SaltLength: 16, KeyLength: 32},
+		{Memory: 64 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 4, KeyLength: 32},
+	} {
+		if err := SetPasswordParams(p); err == nil {
+			t.Errorf("expected %+v to be refused", p)
+		}
+	}
+	if PasswordParams() != defaults {
+		t.Errorf("expected refused parameters to leave the current ones alone")
+	}
+}
@@ -8,6 +8,8 @@ import(
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
+	"slices"
+	"strings"
 )
 
 type TokenType string
@@ -15,55 +17,214 @@ type TokenType string
 const (
 	// TokenTypeAccess -
 	TokenTypeAccess TokenType = "chirpy-access"
+	// TokenTypeEmailVerification is emailed to confirm an address.
+	TokenTypeEmailVerification TokenType = "chirpy-email-verification"
+	// TokenTypePasswordReset is emailed to let a user choose a new password.
+	TokenTypePasswordReset TokenType = "chirpy-password-reset"
+	// TokenTypeTwoFactorChallenge stands in for a login until the second
+	// factor is checked.
+	TokenTypeTwoFactorChallenge TokenType = "chirpy-2fa-challenge"
 )
 
 
-// MakeJWT -
-func MakeJWT(
+// TokenAudience is the aud of every access token, which services verifying
+// them with the JWKS should check.
+const TokenAudience = "chirpy-api"
+
+// Account tiers, as carried in the tier claim.
+const (
+	TierFree = "free"
+	TierRed  = "chirpy-red"
+)
+
+// Claims are the claims Chirpy puts in its tokens. SessionID ties an
+// access token to the login session (refresh token family) it came from.
+// Tokens issued to third-party apps also name the app; their scopes are
+// what the app was granted, while Chirpy's own tokens carry every scope.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Tier      string `json:"tier,omitempty"`
+}
+
+// AccessClaims are the claims of an access token, typed.
+type AccessClaims struct {
+	UserID uuid.UUID
+	// SessionID is the login session, or for app tokens the token family;
+	// uuid.Nil when there's none.
+	SessionID uuid.UUID
+	// ClientID is the app the token was issued to, or uuid.Nil for a
+	// first-party token.
+	ClientID  uuid.UUID
+	TokenID   string
+	Scopes    []string
+	Tier      string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// HasScope reports whether the token may be used for scope.
+func (c *AccessClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// MakeAccessToken makes an access token for c.UserID with c's session,
+// app, scopes and tier. It sets the token ID and times itself, and gives
+// first-party tokens every scope.
+func MakeAccessToken(
+	c AccessClaims,
+	keys *Keyring,
+	expiresIn time.Duration,
+) (string, error) {
+	now := time.Now().UTC()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			Audience:  jwt.ClaimStrings{TokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   c.UserID.String(),
+			ID:        uuid.NewString(),
+		},
+		Scope: strings.Join(c.Scopes, " "),
+		Tier:  c.Tier,
+	}
+	if c.SessionID != uuid.Nil {
+		claims.SessionID = c.SessionID.String()
+	}
+	if c.ClientID != uuid.Nil {
+		claims.ClientID = c.ClientID.String()
+	} else {
+		claims.Scope = strings.Join(AllScopes(), " ")
+	}
+	return keys.sign(claims)
+}
+
+// ValidateJWT checks a first-party access token. Callers must still check
+// it hasn't been revoked since it was issued.
+func ValidateJWT(tokenString string, keys *Keyring) (*AccessClaims, error) {
+	claims, err := validateAccessToken(tokenString, keys)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID != uuid.Nil {
+		return nil, ErrThirdPartyToken
+	}
+	return claims, nil
+}
+
+// ValidateScopedJWT accepts a first-party access token, or one issued to an
+// app that was granted scope; an empty scope accepts any app token. Callers
+// must still check that an app token's family (claims.SessionID) hasn't been
+// revoked.
+func ValidateScopedJWT(tokenString string, keys *Keyring, scope string) (*AccessClaims, error) {
+	claims, err := validateAccessToken(tokenString, keys)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" && !claims.HasScope(scope) {
+		return nil, ErrInsufficientScope
+	}
+	return claims, nil
+}
+
+func validateAccessToken(tokenString string, keys *Keyring) (*AccessClaims, error) {
+	id, claims, err := validateToken(tokenString, TokenTypeAccess, keys.keyFunc, jwt.WithAudience(TokenAudience))
+	if err != nil {
+		return nil, err
+	}
+	// revocation compares against iat, so it can't be optional
+	if claims.IssuedAt == nil {
+		return nil, errors.New("missing issued at")
+	}
+	access := &AccessClaims{
+		UserID:    id,
+		TokenID:   claims.ID,
+		Scopes:    strings.Fields(claims.Scope),
+		Tier:      claims.Tier,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if claims.SessionID != "" {
+		access.SessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session ID: %w", err)
+		}
+	}
+	if claims.ClientID != "" {
+		access.ClientID, err = uuid.Parse(claims.ClientID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client ID: %w", err)
+		}
+	}
+	return access, nil
+}
+
+// MakeSignedToken makes a single-purpose token, such as an email
+// verification link, that can't be used as an access token. Each one has a
+// random ID so callers can store a hash of it and accept it only once.
+// Only Chirpy reads these, so they're always HS256 with the shared secret.
+func MakeSignedToken(
 	userID uuid.UUID,
+	tokenType TokenType,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
+		ID:        uuid.NewString(),
 	})
 	return token.SignedString(signingKey)
 }
 
+// ValidateSignedToken checks a token made by MakeSignedToken for tokenType.
+func ValidateSignedToken(tokenString string, tokenType TokenType, tokenSecret string) (uuid.UUID, error) {
+	id, _, err := validateToken(tokenString, tokenType, secretKey(tokenSecret))
+	return id, err
+}
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+func validateToken(tokenString string, tokenType TokenType, keyFunc jwt.Keyfunc, opts ...jwt.ParserOption) (uuid.UUID, *Claims, error) {
+	claimsStruct := Claims{}
+	opts = append(opts, jwt.WithValidMethods([]string{AlgHS256, AlgEdDSA, AlgRS256}))
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keyFunc,
+		opts...,
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
+	}
+	if issuer != string(tokenType) {
+		return uuid.Nil, nil, errors.New("invalid issuer")
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+
+	expiresAt, err := token.Claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return uuid.Nil, nil, errors.New("missing expiration")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, nil, fmt.Errorf("invalid user ID: %w", err)
 	}
-	return id, nil
+	return id, &claimsStruct, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -71,5 +232,9 @@ func GetBearerToken(headers http.Header) (string, error) {
 	if token == "" {
 		return "", fmt.Errorf("no token found")
 	}
-	return token[7:], nil
+	bearer, found := strings.CutPrefix(token, "Bearer ")
+	if !found || bearer == "" {
+		return "", fmt.Errorf("authorization scheme isn't Bearer")
+	}
+	return bearer, nil
 }
@@ -1,21 +1,166 @@
 package auth
 
 import (
+	"net/http"
+	"slices"
 	"testing"
 	"github.com/google/uuid"
+	"github.com/golang-jwt/jwt/v5"
 	"time"
 )
 
 
-func TestMakeJWT(t *testing.T) {
+func TestMakeAccessToken(t *testing.T) {
 	id := uuid.New()
-	sstring := "The perl is in the liver"
+	keys := NewKeyring("The perl is in the liver")
 	duration := time.Duration(1000000000)
 
-	got, _ := MakeJWT(id, sstring, duration)
-	returnid, _ := ValidateJWT(got, sstring)
-	if returnid != id {
-		t.Errorf("expected %s but got %s", id.String(), returnid.String())
+	got, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, duration)
+	claims, err := ValidateJWT(got, keys)
+	if err != nil || claims.UserID != id {
+		t.Errorf("expected %s but got %+v, %v", id.String(), claims, err)
 	}
 }
 
+func TestValidateJWTExpiry(t *testing.T) {
+	id := uuid.New()
+	keys := NewKeyring("The perl is in the liver")
+
+	got, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+	claims, err := ValidateJWT(got, keys)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if claims.UserID != id {
+		t.Errorf("expected %s but got %s", id.String(), claims.UserID.String())
+	}
+	if d := time.Until(claims.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expected expiry in about an hour but got %s", d)
+	}
+
+	expired, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, -time.Minute)
+	if _, err := ValidateJWT(expired, keys); err == nil {
+		t.Errorf("expected expired token to be rejected")
+	}
+}
+
+func TestSignedTokenPurpose(t *testing.T) {
+	id := uuid.New()
+	sstring := "The perl is in the liver"
+
+	got, _ := MakeSignedToken(id, TokenTypeEmailVerification, sstring, time.Hour)
+	returnid, err := ValidateSignedToken(got, TokenTypeEmailVerification, sstring)
+	if err != nil || returnid != id {
+		t.Errorf("expected %s but got %s, %v", id.String(), returnid.String(), err)
+	}
+
+	// a verification link must never work as an access token, or vice versa
+	if _, err := ValidateJWT(got, NewKeyring(sstring)); err == nil {
+		t.Errorf("expected verification token to be rejected as an access token")
+	}
+	access, _ := MakeAccessToken(AccessClaims{UserID: id}, NewKeyring(sstring), time.Hour)
+	if _, err := ValidateSignedToken(access, TokenTypeEmailVerification, sstring); err == nil {
+		t.Errorf("expected access token to be rejected as a verification token")
+	}
+
+	again, _ := MakeSignedToken(id, TokenTypeEmailVerification, sstring, time.Hour)
+	if again == got {
+		t.Errorf("expected every token to be unique")
+	}
+}
+
+func TestAccessClaims(t *testing.T) {
+	id := uuid.New()
+	session := uuid.New()
+	keys := NewKeyring("The perl is in the liver")
+
+	got, _ := MakeAccessToken(AccessClaims{UserID: id, SessionID: session, Tier: TierRed}, keys, time.Hour)
+	claims, err := ValidateJWT(got, keys)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if claims.UserID != id || claims.SessionID != session || claims.Tier != TierRed {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.TokenID == "" || time.Since(claims.IssuedAt) > time.Minute {
+		t.Errorf("expected a token ID and issue time but got %+v", claims)
+	}
+	// first-party tokens carry every scope
+	if !slices.Equal(claims.Scopes, AllScopes()) {
+		t.Errorf("expected %v but got %v", AllScopes(), claims.Scopes)
+	}
+
+	plain, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+	again, _ := ValidateJWT(plain, keys)
+	if again.SessionID != uuid.Nil {
+		t.Errorf("expected no session but got %s", again.SessionID)
+	}
+	if again.TokenID == claims.TokenID {
+		t.Errorf("expected every token to have its own ID")
+	}
+}
+
+func TestAccessTokenAudience(t *testing.T) {
+	keys := NewKeyring("The perl is in the liver")
+	other, _ := keys.sign(Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Audience:  jwt.ClaimStrings{"someone-else"},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   uuid.NewString(),
+	}})
+	if _, err := ValidateJWT(other, keys); err == nil {
+		t.Errorf("expected a token for another audience to be rejected")
+	}
+}
+
+func TestAppJWTScopes(t *testing.T) {
+	id, family, client := uuid.New(), uuid.New(), uuid.New()
+	keys := NewKeyring("The perl is in the liver")
+
+	token, err := MakeAccessToken(AccessClaims{
+		UserID:    id,
+		SessionID: family,
+		ClientID:  client,
+		Scopes:    []string{ScopeChirpsRead},
+	}, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	claims, err := ValidateScopedJWT(token, keys, ScopeChirpsRead)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if claims.UserID != id || claims.SessionID != family || claims.ClientID != client {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if _, err := ValidateScopedJWT(token, keys, ScopeChirpsWrite); err != ErrInsufficientScope {
+		t.Errorf("expected ErrInsufficientScope but got %v", err)
+	}
+	if _, err := ValidateJWT(token, keys); err != ErrThirdPartyToken {
+		t.Errorf("expected an app token to be refused as a first-party token, got %v", err)
+	}
+
+	// first-party tokens aren't limited by scope
+	first, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+	if _, err := ValidateScopedJWT(first, keys, ScopeProfile); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer abc.def.ghi")
+	token, err := GetBearerToken(headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertCorrectMessage(t, token, "abc.def.ghi")
+
+	for _, bad := range []string{"", "Bearer", "Bearer ", "ApiKey abc.def.ghi", "abc"} {
+		headers.Set("Authorization", bad)
+		if _, err := GetBearerToken(headers); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sealedKeyPrefix marks a private key encrypted by SealPrivateKey, and the
+// scheme, so it can change later.
+const sealedKeyPrefix = "aes256gcm:"
+
+// ParseKeyEncryptionKey decodes a base64 256-bit key-encryption key.
+func ParseKeyEncryptionKey(s string) ([]byte, error) {
+	kek, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		kek, err = base64.RawURLEncoding.DecodeString(s)
+	}
+	if err != nil || len(kek) != 32 {
+		return nil, errors.New("key-encryption key must be 32 bytes, base64 encoded")
+	}
+	return kek, nil
+}
+
+// SealPrivateKey encrypts a signing key's PEM for storage with AES-GCM
+// under kek. The kid is authenticated too, so a sealed key can't be
+// passed off as another.
+func SealPrivateKey(kek []byte, kid, privatePEM string) (string, error) {
+	aead, err := keyCipher(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(privatePEM), []byte(kid))
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// IsSealedPrivateKey reports whether stored came from SealPrivateKey
+// rather than being a plain PEM.
+func IsSealedPrivateKey(stored string) bool {
+	return strings.HasPrefix(stored, sealedKeyPrefix)
+}
+
+// OpenPrivateKey reverses SealPrivateKey.
+func OpenPrivateKey(kek []byte, kid, sealed string) (string, error) {
+	aead, err := keyCipher(kek)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedKeyPrefix))
+	if !IsSealedPrivateKey(sealed) || err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("signing key isn't sealed")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return "", errors.New("couldn't decrypt signing key: wrong key-encryption key or kid")
+	}
+	return string(plain), nil
+}
+
+func keyCipher(kek []byte) (cipher.AEAD, error) {
+	if len(kek) != 32 {
+		return nil, errors.New("no 256-bit key-encryption key")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
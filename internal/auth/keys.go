@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tnaums/chirpy/internal/oidc"
+)
+
+// Access token signing algorithms. HS256 signs with the shared secret; the
+// others sign with a SigningKey whose public half anyone can fetch from the
+// JWKS.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+var ErrUnknownSigningKey = errors.New("token is signed with an unknown or retired key")
+
+// SigningKey is an asymmetric key access tokens are signed with. ID goes in
+// each token's kid header so verifiers know which public key to use.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	// NotAfter is when tokens signed with the key stop being accepted, or
+	// zero while the key hasn't been retired.
+	NotAfter time.Time
+	// SecretUntil is set on the key that took over from HS256: tokens
+	// signed with the secret are accepted until then, so the switch
+	// doesn't log anyone out.
+	SecretUntil time.Time
+}
+
+// GenerateSigningKey makes a new EdDSA (Ed25519) or RS256 (RSA 2048) key
+// with a random ID.
+func GenerateSigningKey(alg string) (SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{ID: hex.EncodeToString(id), Algorithm: alg, Private: private}, nil
+}
+
+// MarshalPrivateKey encodes the private key as a PKCS #8 PEM block.
+func (k SigningKey) MarshalPrivateKey() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseSigningKey reverses MarshalPrivateKey.
+func ParseSigningKey(id, alg, privatePEM string) (SigningKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return SigningKey{}, errors.New("signing key isn't a PKCS #8 PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		if alg == AlgEdDSA {
+			return SigningKey{ID: id, Algorithm: alg, Private: key}, nil
+		}
+	case *rsa.PrivateKey:
+		if alg == AlgRS256 {
+			return SigningKey{ID: id, Algorithm: alg, Private: key}, nil
+		}
+	}
+	return SigningKey{}, fmt.Errorf("signing key %s isn't an %s key", id, alg)
+}
+
+// JWK is the key's public half as a JSON Web Key.
+func (k SigningKey) JWK() oidc.JWK {
+	jwk := oidc.JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+	switch pub := k.Private.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return jwk
+}
+
+func (k SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// Keyring holds the keys access tokens are signed and verified with. Until
+// it's given signing keys it uses HS256 with the shared secret, so a single
+// server works without any key management.
+type Keyring struct {
+	secret string
+
+	mu          sync.RWMutex
+	current     *SigningKey
+	keys        map[string]SigningKey
+	secretUntil time.Time
+}
+
+func NewKeyring(secret string) *Keyring {
+	return &Keyring{secret: secret}
+}
+
+// SetKeys replaces the keyring's signing keys. New tokens are signed with
+// the first one; the rest only verify tokens until their NotAfter, which
+// lets servers and verifiers pick up a new key before the old one goes.
+// No keys goes back to HS256.
+func (k *Keyring) SetKeys(keys []SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = nil
+	k.keys = map[string]SigningKey{}
+	k.secretUntil = time.Time{}
+	for i, key := range keys {
+		if i == 0 {
+			k.current = &key
+		}
+		k.keys[key.ID] = key
+		if key.SecretUntil.After(k.secretUntil) {
+			k.secretUntil = key.SecretUntil
+		}
+	}
+}
+
+// Algorithm is what new tokens are signed with.
+func (k *Keyring) Algorithm() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current == nil {
+		return AlgHS256
+	}
+	return k.current.Algorithm
+}
+
+// JWKS returns the public keys tokens may currently be signed with. It's
+// empty while the keyring uses HS256.
+func (k *Keyring) JWKS() oidc.JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := oidc.JWKS{Keys: []oidc.JWK{}}
+	if k.current != nil {
+		set.Keys = append(set.Keys, k.current.JWK())
+	}
+	for _, key := range k.keys {
+		if key.ID != k.current.ID && (key.NotAfter.IsZero() || time.Now().Before(key.NotAfter)) {
+			set.Keys = append(set.Keys, key.JWK())
+		}
+	}
+	return set
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	current := k.current
+	k.mu.RUnlock()
+	if current == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(k.secret))
+	}
+	token := jwt.NewWithClaims(current.method(), claims)
+	token.Header["kid"] = current.ID
+	return token.SignedString(current.Private)
+}
+
+// keyFunc finds the key a token was signed with. Once the keyring has
+// signing keys, HS256 tokens are only accepted until the first key's
+// SecretUntil.
+func (k *Keyring) keyFunc(t *jwt.Token) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current == nil {
+		return secretKey(k.secret)(t)
+	}
+	if t.Method == jwt.SigningMethodHS256 {
+		if time.Now().Before(k.secretUntil) {
+			return []byte(k.secret), nil
+		}
+		return nil, ErrUnknownSigningKey
+	}
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok || t.Method.Alg() != key.Algorithm {
+		return nil, ErrUnknownSigningKey
+	}
+	if !key.NotAfter.IsZero() && time.Now().After(key.NotAfter) {
+		return nil, ErrUnknownSigningKey
+	}
+	return key.Private.Public(), nil
+}
+
+// secretKey verifies HS256 tokens signed with secret.
+func secretKey(secret string) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return []byte(secret), nil
+	}
+}
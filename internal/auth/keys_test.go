@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestSigningKeys(t *testing.T) {
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateSigningKey(alg)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			keys := NewKeyring("The perl is in the liver")
+			keys.SetKeys([]SigningKey{key})
+
+			id := uuid.New()
+			token, err := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			claims, err := ValidateJWT(token, keys)
+			if err != nil || claims.UserID != id {
+				t.Fatalf("expected %s but got %+v, %v", id, claims, err)
+			}
+
+			// another service only has the JWKS
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if parsed.Header["kid"] != key.ID || parsed.Method.Alg() != alg {
+				t.Errorf("expected kid %s and alg %s but got %v", key.ID, alg, parsed.Header)
+			}
+			set := keys.JWKS()
+			if len(set.Keys) != 1 || set.Keys[0].Kid != key.ID {
+				t.Fatalf("unexpected JWKS %+v", set)
+			}
+			_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+				return set.Keys[0].PublicKey()
+			})
+			if err != nil {
+				t.Errorf("couldn't verify with the JWKS: %s", err)
+			}
+
+			pemKey, err := key.MarshalPrivateKey()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			again, err := ParseSigningKey(key.ID, alg, pemKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			reloaded := NewKeyring("")
+			reloaded.SetKeys([]SigningKey{again})
+			if _, err := ValidateJWT(token, reloaded); err != nil {
+				t.Errorf("expected a parsed key to verify: %s", err)
+			}
+		})
+	}
+}
+
+func TestParseSigningKeyWrongAlgorithm(t *testing.T) {
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	pemKey, _ := key.MarshalPrivateKey()
+	if _, err := ParseSigningKey(key.ID, AlgRS256, pemKey); err == nil {
+		t.Errorf("expected an Ed25519 key to be refused as RS256")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	id := uuid.New()
+	keys := NewKeyring("The perl is in the liver")
+	hs256, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+
+	old, _ := GenerateSigningKey(AlgEdDSA)
+	old.SecretUntil = time.Now().Add(time.Hour)
+	keys.SetKeys([]SigningKey{old})
+	if _, err := ValidateJWT(hs256, keys); err != nil {
+		t.Errorf("expected HS256 tokens to be accepted while the first key is new: %s", err)
+	}
+	old.SecretUntil = time.Now().Add(-time.Second)
+	keys.SetKeys([]SigningKey{old})
+	if _, err := ValidateJWT(hs256, keys); err == nil {
+		t.Errorf("expected HS256 tokens to be refused after the overlap")
+	}
+	before, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+
+	current, _ := GenerateSigningKey(AlgRS256)
+	old.NotAfter = time.Now().Add(time.Hour)
+	keys.SetKeys([]SigningKey{current, old})
+	after, _ := MakeAccessToken(AccessClaims{UserID: id}, keys, time.Hour)
+	for _, token := range []string{before, after} {
+		if _, err := ValidateJWT(token, keys); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	if n := len(keys.JWKS().Keys); n != 2 {
+		t.Errorf("expected both keys in the JWKS but got %d", n)
+	}
+
+	old.NotAfter = time.Now().Add(-time.Second)
+	keys.SetKeys([]SigningKey{current, old})
+	if _, err := ValidateJWT(before, keys); err == nil {
+		t.Errorf("expected a token signed with a retired key to be refused")
+	}
+	if n := len(keys.JWKS().Keys); n != 1 {
+		t.Errorf("expected only the current key in the JWKS but got %d", n)
+	}
+}
+
+func TestSealPrivateKey(t *testing.T) {
+	kek, err := ParseKeyEncryptionKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	pemKey, _ := key.MarshalPrivateKey()
+
+	sealed, err := SealPrivateKey(kek, key.ID, pemKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !IsSealedPrivateKey(sealed) || IsSealedPrivateKey(pemKey) || strings.Contains(sealed, "PRIVATE KEY") {
+		t.Fatalf("expected the key to be sealed but got %q", sealed)
+	}
+	opened, err := OpenPrivateKey(kek, key.ID, sealed)
+	if err != nil || opened != pemKey {
+		t.Fatalf("expected the PEM back but got %v", err)
+	}
+
+	if _, err := OpenPrivateKey(kek, "another-kid", sealed); err == nil {
+		t.Errorf("expected a sealed key not to open under another kid")
+	}
+	other := make([]byte, 32)
+	if _, err := OpenPrivateKey(other, key.ID, sealed); err == nil {
+		t.Errorf("expected a sealed key not to open with another key-encryption key")
+	}
+	if _, err := ParseKeyEncryptionKey("c2hvcnQ="); err == nil {
+		t.Errorf("expected a short key-encryption key to be refused")
+	}
+}
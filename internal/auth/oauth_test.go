@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	got, err := ParseScopes("profile chirps:read  profile")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertCorrectMessage(t, strings.Join(got, " "), "chirps:read profile")
+
+	for _, bad := range []string{"", "  ", "chirps:read admin"} {
+		if _, err := ParseScopes(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := strings.Repeat("abcdefgh-._~", 4)
+	challenge := PKCEChallenge(verifier)
+	if !ValidPKCEChallenge(challenge) {
+		t.Errorf("expected %q to be a valid challenge", challenge)
+	}
+	if !VerifyPKCE(verifier, challenge) {
+		t.Errorf("expected verifier to match its challenge")
+	}
+	if VerifyPKCE(verifier+"x", challenge) {
+		t.Errorf("expected a different verifier to be rejected")
+	}
+	if VerifyPKCE("short", PKCEChallenge("short")) {
+		t.Errorf("expected a verifier under 43 characters to be rejected")
+	}
+	if VerifyPKCE(verifier+"!", PKCEChallenge(verifier+"!")) {
+		t.Errorf("expected a verifier with reserved characters to be rejected")
+	}
+	if ValidPKCEChallenge(verifier) {
+		t.Errorf("expected a plain verifier not to pass as an S256 challenge")
+	}
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Codes for the ways a password can fail a PasswordPolicy.
+const (
+	PasswordRequired = "required"
+	PasswordTooShort = "too_short"
+	PasswordTooLong  = "too_long"
+	PasswordCommon   = "common"
+	PasswordBreached = "breached"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordProblem is one reason a password was refused, with a code for
+// clients to key on and a message they can show as is.
+type PasswordProblem struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy decides which new passwords are acceptable. Lengths are
+// counted in characters; a zero MaxLength means no limit.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// Banned holds lowercased passwords that are always refused.
+	Banned   map[string]bool
+	Breached *BreachedPasswords
+}
+
+// DefaultPasswordPolicy wants eight characters and none of the commonest
+// passwords.
+func DefaultPasswordPolicy() PasswordPolicy {
+	banned, _ := ReadPasswordList(strings.NewReader(commonPasswords))
+	return PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 256,
+		Banned:    banned,
+	}
+}
+
+// Check returns every reason password is refused, or nil if it's fine.
+func (p PasswordPolicy) Check(password string) []PasswordProblem {
+	if password == "" {
+		return []PasswordProblem{{PasswordRequired, "Password is required"}}
+	}
+	var problems []PasswordProblem
+	n := utf8.RuneCountInString(password)
+	if n < p.MinLength {
+		problems = append(problems, PasswordProblem{PasswordTooShort, fmt.Sprintf("Password must be at least %d characters", p.MinLength)})
+	}
+	if p.MaxLength > 0 && n > p.MaxLength {
+		problems = append(problems, PasswordProblem{PasswordTooLong, fmt.Sprintf("Password must be at most %d characters", p.MaxLength)})
+	}
+	if p.Banned[strings.ToLower(password)] {
+		problems = append(problems, PasswordProblem{PasswordCommon, "Password is too common"})
+	} else if p.Breached.Contains(password) {
+		problems = append(problems, PasswordProblem{PasswordBreached, "Password has appeared in a data breach"})
+	}
+	return problems
+}
+
+// ReadPasswordList reads one password per line, skipping blank lines and
+// lines starting with #, and returns them lowercased.
+func ReadPasswordList(r io.Reader) (map[string]bool, error) {
+	list := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = true
+	}
+	return list, scanner.Err()
+}
+
+// BreachedPasswords is a set of SHA-1 password hashes, such as a download
+// of Have I Been Pwned's Pwned Passwords, kept in memory so checking one
+// needs no network call.
+type BreachedPasswords struct {
+	// sorted, for binary search
+	hashes [][sha1.Size]byte
+}
+
+// ReadBreachedPasswords reads HIBP's format: one HASH:COUNT line per
+// password, the hash in hex. A range file holds only the suffixes after
+// a five character prefix; pass that prefix to read one, or "" for a file
+// of whole hashes.
+func ReadBreachedPasswords(r io.Reader, prefix string) (*BreachedPasswords, error) {
+	b := &BreachedPasswords{}
+	if err := b.read(r, prefix); err != nil {
+		return nil, err
+	}
+	b.sort()
+	return b, nil
+}
+
+// LoadBreachedPasswords reads a file of whole hashes, or a directory of
+// range files named after their prefix, such as 5BAA6.txt.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ReadBreachedPasswords(f, "")
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &BreachedPasswords{}
+	for _, e := range entries {
+		prefix := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if e.IsDir() || len(prefix) != 5 {
+			continue
+		}
+		f, err := os.Open(filepath.Join(path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		err = b.read(f, prefix)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+	}
+	b.sort()
+	return b, nil
+}
+
+func (b *BreachedPasswords) read(r io.Reader, prefix string) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		var sum [sha1.Size]byte
+		n, err := hex.Decode(sum[:], []byte(prefix+hash))
+		if err != nil || n != sha1.Size {
+			return fmt.Errorf("line %d: not a SHA-1 hash: %q", line, hash)
+		}
+		b.hashes = append(b.hashes, sum)
+	}
+	return scanner.Err()
+}
+
+func (b *BreachedPasswords) sort() {
+	slices.SortFunc(b.hashes, func(x, y [sha1.Size]byte) int {
+		return bytes.Compare(x[:], y[:])
+	})
+	b.hashes = slices.Compact(b.hashes)
+}
+
+// Len is how many hashes are loaded.
+func (b *BreachedPasswords) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.hashes)
+}
+
+// Contains reports whether password is in the set. A nil set contains
+// nothing.
+func (b *BreachedPasswords) Contains(password string) bool {
+	if b == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	_, found := slices.BinarySearchFunc(b.hashes, sum, func(x, y [sha1.Size]byte) int {
+		return bytes.Compare(x[:], y[:])
+	})
+	return found
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func problemCodes(problems []PasswordProblem) []string {
+	var codes []string
+	for _, p := range problems {
+		codes = append(codes, p.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	sum := sha1.Sum([]byte("correct horse battery"))
+	list := strings.ToUpper(hex.EncodeToString(sum[:])) + ":3\n" +
+		"7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195\n" // 123456
+	breached, err := ReadBreachedPasswords(strings.NewReader(list), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	policy := DefaultPasswordPolicy()
+	policy.Breached = breached
+
+	cases := []struct {
+		password string
+		want     []string
+	}{
+		{"", []string{PasswordRequired}},
+		{"short", []string{PasswordTooShort}},
+		{"PassWord123", []string{PasswordCommon}},
+		{"123456", []string{PasswordTooShort, PasswordCommon}},
+		{"correct horse battery", []string{PasswordBreached}},
+		{strings.Repeat("x", 257), []string{PasswordTooLong}},
+		{"ünïcödé", []string{PasswordTooShort}},
+		{"correct horse battery staple", nil},
+	}
+	for _, c := range cases {
+		got := problemCodes(policy.Check(c.password))
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%q: expected %v but got %v", c.password, c.want, got)
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsRangeFiles(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004\r\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not a range file"), 0o644)
+
+	b, err := LoadBreachedPasswords(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b.Len() != 1 || !b.Contains("password") || b.Contains("Password") {
+		t.Errorf("expected only \"password\" to be breached, got %d hashes", b.Len())
+	}
+}
+
+func TestReadBreachedPasswordsBadLine(t *testing.T) {
+	if _, err := ReadBreachedPasswords(strings.NewReader("not-a-hash:1\n"), ""); err == nil {
+		t.Errorf("expected a malformed line to be refused")
+	}
+}
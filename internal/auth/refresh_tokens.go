@@ -3,14 +3,14 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
 )
 
+// MakeRefreshToken returns a random 256-bit token, hex encoded. Store only
+// its HashToken; the raw value goes to the client and nowhere else.
 func MakeRefreshToken() (string, error) {
-	// Note that no error handling is necessary, as Read always succeeds.
 	key := make([]byte, 32)
-	rand.Read(key)
-	// The key can contain any byte value, print the key in hex.
-	fmt.Printf("% x\n", key)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
 	return hex.EncodeToString(key), nil
 }
@@ -1,15 +1,37 @@
 package auth
 
-import "testing"
+import (
+	"encoding/hex"
+	"testing"
+)
 
 func TestMakeRefreshToken(t *testing.T) {
-	t.Run("Asking for a toke", func(t *testing.T) {
-		got, _ := MakeRefreshToken()
-		want := "myRefreshToken"
-		assertCorrectMessage(t, got, want)
+	t.Run("Asking for a token", func(t *testing.T) {
+		got, err := MakeRefreshToken()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := hex.DecodeString(got); err != nil || len(got) != 64 {
+			t.Errorf("expected 64 hex characters but got %q", got)
+		}
+	})
+
+	t.Run("Tokens are unique", func(t *testing.T) {
+		a, _ := MakeRefreshToken()
+		b, _ := MakeRefreshToken()
+		assertDifferent(t, a, b)
 	})
 }
 
+func TestHashToken(t *testing.T) {
+	token, _ := MakeRefreshToken()
+	assertCorrectMessage(t, HashToken(token), HashToken(token))
+	assertDifferent(t, HashToken(token), token)
+
+	// matches Postgres' encode(sha256(token::bytea), 'hex') used to migrate
+	// existing tokens
+	assertCorrectMessage(t, HashToken("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+}
 
 func assertCorrectMessage(t testing.TB, got, want string) {
 	t.Helper()
@@ -17,3 +39,10 @@ func assertCorrectMessage(t testing.TB, got, want string) {
 		t.Errorf("got %q want %q", got, want)
 	}
 }
+
+func assertDifferent(t testing.TB, a, b string) {
+	t.Helper()
+	if a == b {
+		t.Errorf("expected different values but both were %q", a)
+	}
+}
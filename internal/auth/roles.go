@@ -0,0 +1,53 @@
+package auth
+
+// Roles a user can hold. Everyone is a RoleUser; the others are granted.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions guarding the admin endpoints.
+const (
+	PermMetricsRead   = "metrics:read"
+	PermUsersManage   = "users:manage"
+	PermRolesManage   = "roles:manage"
+	PermSigningKeys   = "signing_keys:manage"
+	PermDatabaseReset = "database:reset"
+)
+
+// RolePermissions is what each role may do. Moderators look after users;
+// admins can do everything.
+var RolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleModerator: {
+		PermMetricsRead,
+		PermUsersManage,
+	},
+	RoleAdmin: {
+		PermMetricsRead,
+		PermUsersManage,
+		PermRolesManage,
+		PermSigningKeys,
+		PermDatabaseReset,
+	},
+}
+
+// GrantableRole reports whether role can be granted, which RoleUser, held
+// by everyone, can't.
+func GrantableRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok && role != RoleUser
+}
+
+// RolesAllow reports whether any of roles has permission.
+func RolesAllow(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "testing"
+
+func TestRolesAllow(t *testing.T) {
+	cases := []struct {
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{nil, PermMetricsRead, false},
+		{[]string{RoleUser}, PermMetricsRead, false},
+		{[]string{RoleModerator}, PermUsersManage, true},
+		{[]string{RoleModerator}, PermRolesManage, false},
+		{[]string{RoleModerator, RoleAdmin}, PermRolesManage, true},
+		{[]string{RoleAdmin}, PermDatabaseReset, true},
+		{[]string{"superuser"}, PermMetricsRead, false},
+	}
+	for _, c := range cases {
+		if got := RolesAllow(c.roles, c.permission); got != c.want {
+			t.Errorf("%v with %s: expected %t but got %t", c.roles, c.permission, c.want, got)
+		}
+	}
+}
+
+func TestGrantableRole(t *testing.T) {
+	for role, want := range map[string]bool{
+		RoleModerator: true,
+		RoleAdmin:     true,
+		RoleUser:      false,
+		"":            false,
+		"root":        false,
+	} {
+		if got := GrantableRole(role); got != want {
+			t.Errorf("%q: expected %t but got %t", role, want, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors for SHA-1, truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		assertCorrectMessage(t, got, c.want)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, _ := GenerateTOTPSecret()
+	now := time.Now()
+	code, _ := TOTPCode(secret, TOTPStep(now))
+
+	step, ok := ValidateTOTP(secret, code, now)
+	if !ok || step != TOTPStep(now) {
+		t.Errorf("expected code to match step %d but got %d, %t", TOTPStep(now), step, ok)
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(TOTPPeriod)); !ok {
+		t.Errorf("expected code from the previous step to be accepted")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(3*TOTPPeriod)); ok {
+		t.Errorf("expected old code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Errorf("expected short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "walt@example.com", "JBSWY3DPEHPK3PXP")
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertCorrectMessage(t, u.Scheme, "otpauth")
+	assertCorrectMessage(t, u.Host, "totp")
+	assertCorrectMessage(t, u.Query().Get("secret"), "JBSWY3DPEHPK3PXP")
+	assertCorrectMessage(t, u.Query().Get("issuer"), "Chirpy")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, _ := MakeRecoveryCode()
+	if len(code) != 14 {
+		t.Errorf("expected a 14 character code but got %q", code)
+	}
+	assertCorrectMessage(t, NormalizeRecoveryCode(" K7QM 2XPT-9hfa "), "k7qm-2xpt-9hfa")
+	assertCorrectMessage(t, NormalizeRecoveryCode(code), code)
+}
@@ -68,12 +68,26 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const listChirps = `-- name: ListChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id FROM chirps
+JOIN users ON users.id = chirps.user_id
+-- hidden from the viewer: blocks either way, mutes, and private authors
+-- the viewer doesn't follow
+WHERE NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = $1 AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = $1)
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = $1 AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = $1 OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = $1 AND follows.followee_id = users.id
+  ))
+ORDER BY chirps.created_at
 `
 
-func (q *Queries) ListChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, listChirps)
+func (q *Queries) ListChirps(ctx context.Context, viewerID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -102,12 +116,32 @@ func (q *Queries) ListChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const listUserChirps = `-- name: ListUserChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1
-ORDER BY created_at
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = $1
+  -- hidden from the viewer: blocks either way, mutes, and private
+  -- authors the viewer doesn't follow
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = $2 AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = $2)
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = $2 AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = $2 OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = $2 AND follows.followee_id = users.id
+  ))
+ORDER BY chirps.created_at
 `
 
-func (q *Queries) ListUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, listUserChirps, userID)
+type ListUserChirpsParams struct {
+	UserID   uuid.UUID
+	ViewerID uuid.UUID
+}
+
+func (q *Queries) ListUserChirps(ctx context.Context, arg ListUserChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listUserChirps, arg.UserID, arg.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -134,3 +168,40 @@ func (q *Queries) ListUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp
 	}
 	return items, nil
 }
+
+const visibleChirpByID = `-- name: VisibleChirpByID :one
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+  -- hidden from the viewer: blocks either way, mutes, and private
+  -- authors the viewer doesn't follow
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = $2 AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = $2)
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = $2 AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = $2 OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = $2 AND follows.followee_id = users.id
+  ))
+`
+
+type VisibleChirpByIDParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.UUID
+}
+
+func (q *Queries) VisibleChirpByID(ctx context.Context, arg VisibleChirpByIDParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, visibleChirpByID, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+	)
+	return i, err
+}
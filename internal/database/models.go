@@ -11,6 +11,28 @@ import (
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	RateLimit  int32
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	LastUsedIp string
+	RevokedAt  sql.NullTime
+}
+
+type Block struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -19,20 +41,270 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
-type RefreshToken struct {
-	Token     string
+type ChirpEvent struct {
+	ID            int64
+	CreatedAt     time.Time
+	Type          string
+	ChirpID       uuid.UUID
+	UserID        uuid.UUID
+	Body          string
+	AuthorPrivate bool
+}
+
+type EmailVerification struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type FollowRequest struct {
+	RequesterID uuid.UUID
+	TargetID    uuid.UUID
+	CreatedAt   time.Time
+}
+
+type FollowerCount struct {
+	UserID    uuid.UUID
+	Followers int64
+}
+
+type List struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	OwnerID     uuid.UUID
+	Name        string
+	Description string
+	IsPrivate   bool
+}
+
+type ListMember struct {
+	ListID    uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type ListSubscription struct {
+	ListID    uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type LoginThrottle struct {
+	ThrottleKey   string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type Mute struct {
+	MuterID   uuid.UUID
+	MutedID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type Notification struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Kind       string
+	GroupKey   string
+	ActorID    uuid.NullUUID
+	ChirpID    uuid.NullUUID
+	ActorCount int32
+	ReadAt     sql.NullTime
+}
+
+type NotificationActor struct {
+	NotificationID uuid.UUID
+	ActorID        uuid.UUID
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	RedirectUris []string
+	SecretHash   sql.NullString
+}
+
+type OauthCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	FamilyID      uuid.UUID
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthGrant struct {
+	ClientID  uuid.UUID
+	UserID    uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	Scopes    []string
+}
+
+type OauthToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	FamilyID  uuid.UUID
+	ClientID  uuid.UUID
 	UserID    uuid.UUID
+	Scopes    []string
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
 }
 
-type User struct {
-	ID             uuid.UUID
+type OidcLogin struct {
+	StateHash    string
+	CreatedAt    time.Time
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       uuid.NullUUID
+	ExpiresAt    time.Time
+}
+
+type PasswordReset struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Recommendation struct {
+	UserID        uuid.UUID
+	RecommendedID uuid.UUID
+	Score         float64
+	Reason        string
+	ComputedAt    time.Time
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash        string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	UserID           uuid.UUID
+	ExpiresAt        time.Time
+	RevokedAt        sql.NullTime
+	FamilyID         uuid.UUID
+	ReplacedBy       sql.NullString
+	UserAgent        string
+	Ip               string
+	SessionStartedAt time.Time
+	LastUsedAt       time.Time
+}
+
+type SecurityEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Kind      string
+	Ip        string
+	UserAgent string
+	Detail    string
+}
+
+type SigningKey struct {
+	Kid            string
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	Algorithm      string
+	PrivateKey     string
+	RetiredAt      sql.NullTime
+	ReplacesSecret bool
+}
+
+type User struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Email            string
+	HashedPassword   string
+	IsChirpyRed      bool
+	Handle           string
+	DisplayName      string
+	Bio              string
+	Avatar           string
+	IsPrivate        bool
+	EmailVerifiedAt  sql.NullTime
+	TotpSecret       sql.NullString
+	TotpEnabledAt    sql.NullTime
+	TotpLastStep     int64
+	TokensValidAfter sql.NullTime
+}
+
+type UserIdentity struct {
+	Provider    string
+	Subject     string
+	UserID      uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+type UserRole struct {
+	UserID    uuid.UUID
+	Role      string
+	GrantedAt time.Time
+	GrantedBy uuid.NullUUID
+}
+
+type WebhookAttempt struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	DeliveryID   uuid.UUID
+	ResponseCode sql.NullInt32
+	Error        string
+	DurationMs   int32
+}
+
+type WebhookDelivery struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	EndpointID       uuid.UUID
+	Event            string
+	Payload          string
+	Status           string
+	Attempts         int32
+	NextAttemptAt    time.Time
+	LastResponseCode sql.NullInt32
+	LastError        string
+}
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	UserID              uuid.UUID
+	Url                 string
+	Secret              string
+	Events              []string
+	ConsecutiveFailures int32
+	DisabledAt          sql.NullTime
 }
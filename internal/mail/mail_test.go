@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := FileMailer{Dir: dir, From: "chirpy@example.com"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "walt@example.com",
+		Subject: "Verify your email",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 message but found %d", len(files))
+	}
+	dat, _ := os.ReadFile(files[0])
+	for _, want := range []string{"To: walt@example.com\r\n", "Subject: Verify your email\r\n", "\r\n\r\nline one\r\nline two"} {
+		if !strings.Contains(string(dat), want) {
+			t.Errorf("expected message to contain %q but got %q", want, dat)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package oidc
+
+import "time"
+
+// SetMinRefresh lets tests roll keys over without waiting.
+func SetMinRefresh(c *Client, d time.Duration) {
+	c.keys.minRefresh = d
+}
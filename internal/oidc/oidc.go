@@ -0,0 +1,231 @@
+// Package oidc signs users in with an external OpenID Connect provider
+// using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrIssuerMismatch = errors.New("discovery document is for a different issuer")
+	ErrNonceMismatch  = errors.New("ID token nonce doesn't match")
+	ErrNoIDToken      = errors.New("token response has no ID token")
+)
+
+// Identity is who the provider says signed in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an identity provider users can sign in with.
+type Provider interface {
+	// AuthURL is where to send the user to sign in. codeChallenge is the
+	// S256 PKCE challenge for the verifier later passed to Exchange.
+	AuthURL(state, nonce, codeChallenge string) string
+	// Exchange redeems an authorization code and returns the identity from
+	// its ID token, once the token's signature and claims check out.
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error)
+}
+
+// Config is how Chirpy is registered with a provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Discovery is the part of a provider's discovery document Chirpy uses
+// (OpenID Connect Discovery 1.0 section 3).
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Client is a Provider configured from the issuer's discovery document.
+type Client struct {
+	config    Config
+	discovery Discovery
+	keys      *keySet
+	http      *http.Client
+}
+
+// Discover fetches the issuer's discovery document and returns a Client
+// for it.
+func Discover(ctx context.Context, config Config, httpClient *http.Client) (*Client, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	d := Discovery{}
+	err := getJSON(ctx, httpClient, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch discovery document: %w", err)
+	}
+	// the issuer must match exactly, or ID tokens from elsewhere could pass
+	if d.Issuer != config.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing an endpoint")
+	}
+	return &Client{
+		config:    config,
+		discovery: d,
+		keys:      newKeySet(d.JWKSURI, httpClient),
+		http:      httpClient,
+	}, nil
+}
+
+func (c *Client) AuthURL(state, nonce, codeChallenge string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(c.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.discovery.AuthorizationEndpoint + sep + q.Encode()
+}
+
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	// client_secret_basic is the default when the provider doesn't say
+	basic := len(c.discovery.TokenAuthMethods) == 0 || slices.Contains(c.discovery.TokenAuthMethods, "client_secret_basic")
+	if !basic {
+		form.Set("client_id", c.config.ClientID)
+		form.Set("client_secret", c.config.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Identity{}, err
+	}
+	if resp.StatusCode != 200 {
+		return Identity{}, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return Identity{}, err
+	}
+	if tokens.IDToken == "" {
+		return Identity{}, ErrNoIDToken
+	}
+	return c.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// idClaims are the ID token claims Chirpy reads. Some providers send
+// email_verified as a string.
+type idClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	AuthorizedBy  string `json:"azp"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Verify checks an ID token's signature against the provider's JWKS and
+// its claims against OpenID Connect Core section 3.1.3.7.
+func (c *Client) Verify(ctx context.Context, idToken, nonce string) (Identity, error) {
+	claims := idClaims{}
+	_, err := jwt.ParseWithClaims(idToken, &claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return c.keys.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(c.config.Issuer),
+		jwt.WithAudience(c.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Identity{}, err
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != c.config.ClientID {
+		return Identity{}, errors.New("ID token was issued to another client")
+	}
+	if claims.Nonce != nonce {
+		return Identity{}, ErrNonceMismatch
+	}
+	if claims.Subject == "" {
+		return Identity{}, errors.New("ID token has no subject")
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+	return Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified && claims.Email != "",
+		Name:          claims.Name,
+	}, nil
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,175 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tnaums/chirpy/internal/oidc"
+	"github.com/tnaums/chirpy/internal/oidc/oidctest"
+)
+
+const verifier = "dBjftJeZ4CVP-mB92K1uhbHjKt5pLGlpTwxIw07sZVuW"
+
+func challenge(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func setup(t *testing.T) (*oidctest.IdP, *oidc.Client) {
+	t.Helper()
+	idp, srv := oidctest.NewServer("chirpy", "s3cret")
+	t.Cleanup(srv.Close)
+
+	client, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     "chirpy",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8080/api/oidc/test/callback",
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return idp, client
+}
+
+// login follows the authorization redirect the way a browser would and
+// returns the code and state sent back to Chirpy.
+func login(t *testing.T, client *oidc.Client, nonce string) (string, string) {
+	t.Helper()
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := browser.Get(client.AuthURL("state-1", nonce, challenge(verifier)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect back but got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if !strings.HasPrefix(loc.String(), "http://localhost:8080/api/oidc/test/callback?") {
+		t.Fatalf("redirected to %s", loc)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestLogin(t *testing.T) {
+	_, client := setup(t)
+
+	code, state := login(t, client, "nonce-1")
+	if state != "state-1" {
+		t.Errorf("expected state to round-trip but got %q", state)
+	}
+	id, err := client.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id.Subject != "248289761001" || id.Email != "jane@example.com" || !id.EmailVerified {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	// codes are single use
+	if _, err := client.Exchange(context.Background(), code, verifier, "nonce-1"); err == nil {
+		t.Errorf("expected a used code to be rejected")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	_, client := setup(t)
+
+	code, _ := login(t, client, "nonce-1")
+	if _, err := client.Exchange(context.Background(), code, verifier+"x", "nonce-1"); err == nil {
+		t.Errorf("expected a wrong PKCE verifier to be rejected")
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	_, client := setup(t)
+
+	code, _ := login(t, client, "nonce-1")
+	if _, err := client.Exchange(context.Background(), code, verifier, "nonce-2"); err != oidc.ErrNonceMismatch {
+		t.Errorf("expected ErrNonceMismatch but got %v", err)
+	}
+}
+
+func TestExchangeRejectsBadClaims(t *testing.T) {
+	cases := map[string]func(jwt.MapClaims){
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"other azp":      func(c jwt.MapClaims) { c["aud"] = []string{"chirpy", "other"}; c["azp"] = "other" },
+		"no subject":     func(c jwt.MapClaims) { c["sub"] = "" },
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			idp, client := setup(t)
+			idp.SetTamper(tamper)
+
+			code, _ := login(t, client, "nonce-1")
+			if _, err := client.Exchange(context.Background(), code, verifier, "nonce-1"); err == nil {
+				t.Errorf("expected the ID token to be rejected")
+			}
+		})
+	}
+}
+
+func TestUnverifiedEmail(t *testing.T) {
+	cases := []struct {
+		verified any
+		want     bool
+	}{
+		{false, false},
+		{"false", false},
+		{"true", true},
+		{nil, false},
+	}
+	for _, c := range cases {
+		idp, client := setup(t)
+		idp.SetTamper(func(claims jwt.MapClaims) { claims["email_verified"] = c.verified })
+
+		code, _ := login(t, client, "n")
+		id, err := client.Exchange(context.Background(), code, verifier, "n")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if id.EmailVerified != c.want {
+			t.Errorf("email_verified %#v: expected %v but got %v", c.verified, c.want, id.EmailVerified)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	idp, client := setup(t)
+	oidc.SetMinRefresh(client, 0)
+
+	code, _ := login(t, client, "n")
+	if _, err := client.Exchange(context.Background(), code, verifier, "n"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the new key isn't cached yet, so the client must refetch the JWKS
+	idp.RotateKey()
+	code, _ = login(t, client, "n")
+	if _, err := client.Exchange(context.Background(), code, verifier, "n"); err != nil {
+		t.Errorf("expected a token signed with the rotated key to verify, got %s", err)
+	}
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	_, srv := oidctest.NewServer("chirpy", "s3cret")
+	defer srv.Close()
+
+	_, err := oidc.Discover(context.Background(), oidc.Config{Issuer: srv.URL + "/"}, srv.Client())
+	if err != oidc.ErrIssuerMismatch {
+		t.Errorf("expected ErrIssuerMismatch but got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Package ratelimit counts requests per key in fixed windows.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Result is the outcome of one Allow call.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the current window ends and the count starts over.
+	Reset time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter allows up to a per-call limit of requests per key in each
+// window. It keeps counts in memory, so each server instance limits on its
+// own. The zero value isn't usable; call New.
+type Limiter struct {
+	period time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+func New(period time.Duration) *Limiter {
+	return &Limiter{period: period, windows: map[string]*window{}}
+}
+
+// Allow counts a request for key at now and reports whether it's within
+// limit.
+func (l *Limiter) Allow(key string, limit int, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.start.Add(l.period)) {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	res := Result{Limit: limit, Reset: w.start.Add(l.period)}
+	if w.count >= limit {
+		return res
+	}
+	w.count++
+	res.Allowed = true
+	res.Remaining = limit - w.count
+	return res
+}
+
+// sweep forgets finished windows now and then, so keys that stop sending
+// requests don't pile up.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.period {
+		return
+	}
+	for key, w := range l.windows {
+		if !now.Before(w.start.Add(l.period)) {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
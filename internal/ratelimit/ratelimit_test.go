@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New(time.Minute)
+	start := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		res := l.Allow("a", 3, start.Add(time.Duration(i)*time.Second))
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected result %+v", i, res)
+		}
+	}
+	res := l.Allow("a", 3, start.Add(10*time.Second))
+	if res.Allowed || res.Remaining != 0 {
+		t.Errorf("expected the fourth request to be refused, got %+v", res)
+	}
+	if !res.Reset.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected reset at %s but got %s", start.Add(time.Minute), res.Reset)
+	}
+
+	// other keys have their own count
+	if res := l.Allow("b", 3, start.Add(10*time.Second)); !res.Allowed {
+		t.Errorf("expected another key to be allowed")
+	}
+
+	// the count starts over in the next window
+	if res := l.Allow("a", 3, start.Add(time.Minute)); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("expected a new window, got %+v", res)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(time.Minute)
+	start := time.Unix(1700000000, 0)
+
+	l.Allow("a", 1, start)
+	l.Allow("b", 1, start.Add(2*time.Minute))
+	if _, ok := l.windows["a"]; ok {
+		t.Errorf("expected the finished window to be swept")
+	}
+	if _, ok := l.windows["b"]; !ok {
+		t.Errorf("expected the current window to be kept")
+	}
+}
@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EventChirpCreated EventType = "chirp.created"
+	EventChirpUpdated EventType = "chirp.updated"
+	EventChirpDeleted EventType = "chirp.deleted"
+)
+
+// Event is one change to a chirp. IDs increase monotonically across every
+// instance, so a client can resume from the last ID it saw.
+type Event struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Type      EventType `json:"type"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	AuthorID  uuid.UUID `json:"author_id"`
+	Body      string    `json:"body"`
+	// whether the author's account was private when the chirp changed
+	AuthorPrivate bool `json:"author_private"`
+}
+
+// Subscription receives the events accepted by its filter on C. C is closed
+// when the subscriber falls too far behind or unsubscribes; Dropped reports
+// which of the two happened once C is closed.
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	filter  func(Event) bool
+	dropped bool
+}
+
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
+
+// Broker fans events out to subscribers in process. Publish never blocks:
+// a subscriber whose buffer is full is dropped instead of slowing everyone
+// else down, and is expected to reconnect and resume from its last event.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe registers a subscriber with room for buffer pending events.
+// A nil filter accepts every event.
+func (b *Broker) Subscribe(filter func(Event) bool, buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped = true
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribers returns the number of live subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
@@ -0,0 +1,65 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPublishFiltersEvents(t *testing.T) {
+	b := NewBroker()
+	author := uuid.New()
+	sub := b.Subscribe(func(e Event) bool { return e.AuthorID == author }, 10)
+
+	b.Publish(Event{ID: 1, AuthorID: uuid.New()})
+	b.Publish(Event{ID: 2, AuthorID: author})
+
+	got := <-sub.C
+	if got.ID != 2 {
+		t.Errorf("expected event 2 but got %d", got.ID)
+	}
+	if len(sub.C) != 0 {
+		t.Errorf("expected no more events but %d are queued", len(sub.C))
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker()
+	slow := b.Subscribe(nil, 1)
+	fast := b.Subscribe(nil, 10)
+
+	b.Publish(Event{ID: 1})
+	b.Publish(Event{ID: 2})
+
+	if !slow.Dropped() {
+		t.Errorf("expected slow subscriber to be dropped")
+	}
+	if fast.Dropped() {
+		t.Errorf("expected fast subscriber to stay subscribed")
+	}
+	if got := b.Subscribers(); got != 1 {
+		t.Errorf("expected 1 subscriber but got %d", got)
+	}
+
+	// the buffered event is still delivered before the channel closes
+	if e, ok := <-slow.C; !ok || e.ID != 1 {
+		t.Errorf("expected buffered event 1 but got %v, %t", e.ID, ok)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Errorf("expected channel to be closed")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := NewBroker()
+	sub := b.Subscribe(nil, 1)
+	b.Unsubscribe(sub)
+	b.Unsubscribe(sub)
+
+	if _, ok := <-sub.C; ok {
+		t.Errorf("expected channel to be closed")
+	}
+	if sub.Dropped() {
+		t.Errorf("expected unsubscribe not to count as a drop")
+	}
+}
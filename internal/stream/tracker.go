@@ -0,0 +1,53 @@
+package stream
+
+// Tracker remembers which event ids have been handled. Ids are assigned
+// when an event is inserted but become visible when its transaction
+// commits, so they arrive out of order. A single high-water mark would
+// discard a late, lower id, so Tracker keeps a floor below which every id
+// has been handled, plus the ids handled above it.
+type Tracker struct {
+	floor int64
+	seen  map[int64]bool
+	// maxGap is how many ids may pile up above a missing one before it's
+	// given up on. Rolled back inserts leave ids that never arrive.
+	maxGap int
+}
+
+// NewTracker returns a Tracker that has handled nothing.
+func NewTracker(maxGap int) *Tracker {
+	return &Tracker{seen: map[int64]bool{}, maxGap: maxGap}
+}
+
+// Start sets the floor for a tracker that hasn't seen anything, such as
+// when the first event after startup arrives.
+func (t *Tracker) Start(floor int64) {
+	if t.floor == 0 && len(t.seen) == 0 {
+		t.floor = floor
+	}
+}
+
+// Add records id and reports whether it's new.
+func (t *Tracker) Add(id int64) bool {
+	if id <= t.floor || t.seen[id] {
+		return false
+	}
+	t.seen[id] = true
+	if len(t.seen) > t.maxGap {
+		lowest := id
+		for s := range t.seen {
+			lowest = min(lowest, s)
+		}
+		t.floor = lowest - 1
+	}
+	for t.seen[t.floor+1] {
+		delete(t.seen, t.floor+1)
+		t.floor++
+	}
+	return true
+}
+
+// Floor is the id below which nothing is missing: backfills start after
+// it.
+func (t *Tracker) Floor() int64 {
+	return t.floor
+}
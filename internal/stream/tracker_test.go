@@ -0,0 +1,48 @@
+package stream
+
+import "testing"
+
+func TestTrackerOutOfOrder(t *testing.T) {
+	tr := NewTracker(100)
+	tr.Start(10)
+
+	// 12 commits before 11
+	for _, id := range []int64{12, 11, 13} {
+		if !tr.Add(id) {
+			t.Errorf("expected %d to be new", id)
+		}
+	}
+	if tr.Floor() != 13 {
+		t.Errorf("expected floor 13 but got %d", tr.Floor())
+	}
+	for _, id := range []int64{10, 12, 13} {
+		if tr.Add(id) {
+			t.Errorf("expected %d to be a repeat", id)
+		}
+	}
+}
+
+func TestTrackerGapHoldsFloor(t *testing.T) {
+	tr := NewTracker(3)
+	tr.Start(1)
+	tr.Add(3)
+	tr.Add(4)
+	if tr.Floor() != 1 {
+		t.Errorf("expected a missing 2 to hold the floor at 1 but got %d", tr.Floor())
+	}
+	if !tr.Add(2) || tr.Floor() != 4 {
+		t.Errorf("expected a late 2 to be new and lift the floor to 4, got %d", tr.Floor())
+	}
+
+	// 6 never arrives
+	tr.Add(7)
+	tr.Add(8)
+	tr.Add(9)
+	if tr.Floor() != 4 {
+		t.Errorf("expected the floor to wait for 5 and 6 but got %d", tr.Floor())
+	}
+	tr.Add(10)
+	if tr.Floor() != 10 {
+		t.Errorf("expected the gap to be given up on, floor 10, but got %d", tr.Floor())
+	}
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::":               false,
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false,
+		"224.0.0.1":        false,
+		"255.255.255.255":  false,
+	} {
+		if got := PublicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s: expected %t but got %t", addr, want, got)
+		}
+	}
+}
+
+func TestDialControlRefusesLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dialer := &net.Dialer{Control: DialControl}
+	_, err := dialer.DialContext(context.Background(), "tcp", srv.Listener.Addr().String())
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected the dial to be refused but got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"event":"chirp.created"}`)
+	now := time.Now()
+
+	header := Sign(secret, now, body)
+	if err := Verify(secret, header, body, 5*time.Minute, now); err != nil {
+		t.Errorf("expected valid signature but got %s", err)
+	}
+	if err := Verify("whsec_other", header, body, 5*time.Minute, now); err != ErrSignatureMismatch {
+		t.Errorf("expected mismatch for the wrong secret but got %v", err)
+	}
+	if err := Verify(secret, header, []byte(`{}`), 5*time.Minute, now); err != ErrSignatureMismatch {
+		t.Errorf("expected mismatch for a tampered body but got %v", err)
+	}
+}
+
+func TestVerifyRejectsStaleTimestamp(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{}`)
+	sent := time.Now().Add(-time.Hour)
+
+	header := Sign(secret, sent, body)
+	if err := Verify(secret, header, body, 5*time.Minute, time.Now()); err != ErrTimestampTooOld {
+		t.Errorf("expected stale timestamp error but got %v", err)
+	}
+}
+
+func TestVerifyRejectsMalformedHeader(t *testing.T) {
+	for _, header := range []string{"", "v1=abc", "t=abc,v1=abc", "t=123"} {
+		if err := Verify("s", header, nil, time.Minute, time.Now()); err != ErrMalformedSignature {
+			t.Errorf("expected malformed error for %q but got %v", header, err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := time.Second
+	max := time.Minute
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{20, time.Minute},
+	}
+	for _, c := range cases {
+		got := Backoff(c.attempts, base, max)
+		if got < c.want || got > c.want+c.want/5 {
+			t.Errorf("attempt %d: expected %s plus jitter but got %s", c.attempts, c.want, got)
+		}
+	}
+}
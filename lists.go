@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+type List struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	OwnerID     uuid.UUID `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsPrivate   bool      `json:"is_private"`
+}
+
+const (
+	maxListNameLength        = 50
+	maxListDescriptionLength = 160
+	maxListMembers           = 500
+
+	defaultTimelineChirps = 50
+	maxTimelineChirps     = 200
+)
+
+func listFromDB(l database.List) List {
+	return List{
+		ID:          l.ID,
+		CreatedAt:   l.CreatedAt,
+		UpdatedAt:   l.UpdatedAt,
+		OwnerID:     l.OwnerID,
+		Name:        l.Name,
+		Description: l.Description,
+		IsPrivate:   l.IsPrivate,
+	}
+}
+
+func validateList(name, description string) string {
+	if strings.TrimSpace(name) == "" {
+		return "List name is required"
+	}
+	if utf8.RuneCountInString(name) > maxListNameLength {
+		return "List name is too long"
+	}
+	if utf8.RuneCountInString(description) > maxListDescriptionLength {
+		return "List description is too long"
+	}
+	return ""
+}
+
+// visibleList loads the {listID} list if viewer may see it. Private lists are
+// only visible to their owner; everyone else gets a 404.
+func (cfg *apiConfig) visibleList(w http.ResponseWriter, r *http.Request, viewer uuid.UUID) (database.List, bool) {
+	id, err := uuid.Parse(r.PathValue("listID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid list id")
+		return database.List{}, false
+	}
+
+	list, err := cfg.queries.GetList(context.Background(), id)
+	if err != nil || (list.IsPrivate && list.OwnerID != viewer) {
+		w.WriteHeader(404)
+		return database.List{}, false
+	}
+	return list, true
+}
+
+// ownedList is visibleList for endpoints that modify the list.
+func (cfg *apiConfig) ownedList(w http.ResponseWriter, r *http.Request) (database.List, bool) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return database.List{}, false
+	}
+
+	list, ok := cfg.visibleList(w, r, caller)
+	if !ok {
+		return database.List{}, false
+	}
+	if list.OwnerID != caller {
+		respondWithError(w, 403, "You don't own that list")
+		return database.List{}, false
+	}
+	return list, true
+}
+
+func (cfg *apiConfig) createList(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		IsPrivate   bool   `json:"is_private"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if msg := validateList(params.Name, params.Description); msg != "" {
+		respondWithError(w, 400, msg)
+		return
+	}
+
+	list, err := cfg.queries.CreateList(context.Background(), database.CreateListParams{
+		OwnerID:     caller,
+		Name:        strings.TrimSpace(params.Name),
+		Description: params.Description,
+		IsPrivate:   params.IsPrivate,
+	})
+	if err != nil {
+		log.Printf("couldn't create list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	respondWithBody(w, 201, listFromDB(list))
+}
+
+// myLists returns the lists the caller owns and the lists they subscribe to.
+func (cfg *apiConfig) myLists(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	owned, err := cfg.queries.ListOwnedLists(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list owned lists: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	subscribed, err := cfg.queries.ListSubscribedLists(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list subscribed lists: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		Owned      []List `json:"owned"`
+		Subscribed []List `json:"subscribed"`
+	}
+	resp := response{Owned: []List{}, Subscribed: []List{}}
+	for _, l := range owned {
+		resp.Owned = append(resp.Owned, listFromDB(l))
+	}
+	for _, l := range subscribed {
+		resp.Subscribed = append(resp.Subscribed, listFromDB(l))
+	}
+	respondWithBody(w, 200, resp)
+}
+
+func (cfg *apiConfig) getList(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.visibleList(w, r, cfg.viewerID(r))
+	if !ok {
+		return
+	}
+	respondWithBody(w, 200, listFromDB(list))
+}
+
+func (cfg *apiConfig) updateList(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		IsPrivate   *bool   `json:"is_private"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	update := database.UpdateListParams{
+		ID:          list.ID,
+		Name:        list.Name,
+		Description: list.Description,
+		IsPrivate:   list.IsPrivate,
+	}
+	if params.Name != nil {
+		update.Name = strings.TrimSpace(*params.Name)
+	}
+	if params.Description != nil {
+		update.Description = *params.Description
+	}
+	if params.IsPrivate != nil {
+		update.IsPrivate = *params.IsPrivate
+	}
+	if msg := validateList(update.Name, update.Description); msg != "" {
+		respondWithError(w, 400, msg)
+		return
+	}
+
+	list, err = cfg.queries.UpdateList(context.Background(), update)
+	if err != nil {
+		log.Printf("couldn't update list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	respondWithBody(w, 200, listFromDB(list))
+}
+
+func (cfg *apiConfig) deleteList(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.DeleteList(context.Background(), list.ID)
+	if err != nil {
+		log.Printf("couldn't delete list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) listMembers(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.visibleList(w, r, cfg.viewerID(r))
+	if !ok {
+		return
+	}
+
+	users, err := cfg.queries.ListListMembers(context.Background(), list.ID)
+	if err != nil {
+		log.Printf("couldn't list members: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	profiles := []Profile{}
+	for _, u := range users {
+		profiles = append(profiles, profileFromUser(u))
+	}
+	respondWithBody(w, 200, profiles)
+}
+
+func (cfg *apiConfig) addListMember(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	member, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+	_, err = cfg.queries.GetUserByID(context.Background(), member)
+	if err != nil {
+		log.Printf("couldn't find user %s: %s", member, err)
+		w.WriteHeader(404)
+		return
+	}
+
+	blocked, err := cfg.queries.IsBlockedEitherWay(context.Background(), database.IsBlockedEitherWayParams{
+		UserA: list.OwnerID,
+		UserB: member,
+	})
+	if err != nil {
+		log.Printf("couldn't check blocks: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if blocked {
+		respondWithError(w, 403, "You cannot add this user to a list")
+		return
+	}
+
+	count, err := cfg.queries.CountListMembers(context.Background(), list.ID)
+	if err != nil {
+		log.Printf("couldn't count members: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if count >= maxListMembers {
+		respondWithError(w, 400, "List is full")
+		return
+	}
+
+	err = cfg.queries.AddListMember(context.Background(), database.AddListMemberParams{
+		ListID: list.ID,
+		UserID: member,
+	})
+	if err != nil {
+		log.Printf("couldn't add member: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) removeListMember(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	member, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+
+	err = cfg.queries.RemoveListMember(context.Background(), database.RemoveListMemberParams{
+		ListID: list.ID,
+		UserID: member,
+	})
+	if err != nil {
+		log.Printf("couldn't remove member: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) listTimeline(w http.ResponseWriter, r *http.Request) {
+	viewer := cfg.viewerID(r)
+	list, ok := cfg.visibleList(w, r, viewer)
+	if !ok {
+		return
+	}
+
+	limit := defaultTimelineChirps
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = min(n, maxTimelineChirps)
+	}
+
+	// start past every possible row when there's no cursor
+	beforeTime := time.Now().Add(24 * time.Hour)
+	beforeID := uuid.Max
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var err error
+		beforeTime, beforeID, err = decodeCursor(c)
+		if err != nil {
+			respondWithError(w, 400, "Invalid cursor")
+			return
+		}
+	}
+
+	// hidden authors are left out in the query, so a full page means
+	// there may be more
+	chirps, err := cfg.queries.ListTimelineChirps(context.Background(), database.ListTimelineChirpsParams{
+		ListID:     list.ID,
+		BeforeTime: beforeTime,
+		BeforeID:   beforeID,
+		ViewerID:   viewer,
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		log.Printf("couldn't retrieve timeline: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+	resp := response{Chirps: []Chirp{}}
+	for _, c := range chirps {
+		resp.Chirps = append(resp.Chirps, chirpFromDB(c))
+	}
+	if len(chirps) == limit {
+		last := chirps[len(chirps)-1]
+		resp.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	respondWithBody(w, 200, resp)
+}
+
+func (cfg *apiConfig) subscribeList(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	list, ok := cfg.visibleList(w, r, caller)
+	if !ok {
+		return
+	}
+
+	err = cfg.queries.SubscribeList(context.Background(), database.SubscribeListParams{
+		ListID: list.ID,
+		UserID: caller,
+	})
+	if err != nil {
+		log.Printf("couldn't subscribe to list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unsubscribeList(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("listID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid list id")
+		return
+	}
+
+	// no visibility check here so a list turning private can still be left
+	err = cfg.queries.UnsubscribeList(context.Background(), database.UnsubscribeListParams{
+		ListID: id,
+		UserID: caller,
+	})
+	if err != nil {
+		log.Printf("couldn't unsubscribe from list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/mail"
+)
+
+const (
+	// failures are counted until there's been none for this long
+	loginFailureWindow = 15 * time.Minute
+	loginLockout       = 15 * time.Minute
+	loginMaxDelay      = time.Minute
+)
+
+// loginPolicy is how many failures a throttle key gets for free before
+// each further attempt has to wait, doubling from a second, and how many
+// lock it.
+type loginPolicy struct {
+	free   int32
+	lockAt int32
+}
+
+var (
+	accountLoginPolicy = loginPolicy{free: 3, lockAt: 10}
+	// an address may be shared by many people, but spraying passwords
+	// across accounts still shows up here
+	ipLoginPolicy = loginPolicy{free: 20, lockAt: 100}
+)
+
+func (p loginPolicy) delay(failures int32) time.Duration {
+	if failures < p.free {
+		return 0
+	}
+	d := time.Second << min(failures-p.free, 6)
+	return min(d, loginMaxDelay)
+}
+
+// accountThrottleKey is keyed by email rather than user so unknown emails
+// are throttled exactly like real ones.
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loginPolicyFor(key string) loginPolicy {
+	if strings.HasPrefix(key, "ip:") {
+		return ipLoginPolicy
+	}
+	return accountLoginPolicy
+}
+
+// loginWait is how long a login for email from r has to wait, because of
+// earlier failures or a lockout. Zero means go ahead.
+func (cfg *apiConfig) loginWait(ctx context.Context, r *http.Request, email string) (time.Duration, error) {
+	throttles, err := cfg.queries.GetLoginThrottles(ctx, []string{accountThrottleKey(email), ipThrottleKey(clientIP(r))})
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	wait := time.Duration(0)
+	for _, t := range throttles {
+		if t.LockedUntil.Valid {
+			wait = max(wait, t.LockedUntil.Time.Sub(now))
+		}
+		if t.LastFailureAt.After(now.Add(-loginFailureWindow)) {
+			next := t.LastFailureAt.Add(loginPolicyFor(t.ThrottleKey).delay(t.Failures))
+			wait = max(wait, next.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// loginThrottlesLoop deletes throttles nobody has failed against for a
+// whole window, once an hour, since they no longer slow anyone down.
+func (cfg *apiConfig) loginThrottlesLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := cfg.queries.DeleteStaleLoginThrottles(context.Background(), time.Now().UTC().Add(-loginFailureWindow))
+		if err != nil {
+			log.Printf("couldn't prune login throttles: %s", err)
+		}
+	}
+}
+
+// errBadCredentials means an email and password didn't match. It's the
+// same whether or not the email has an account.
+var errBadCredentials = errors.New("incorrect email or password")
+
+// loginThrottledError means a login was refused without checking the
+// password, because of earlier failures.
+type loginThrottledError struct {
+	wait time.Duration
+}
+
+func (e *loginThrottledError) Error() string {
+	return "login throttled for " + e.wait.String()
+}
+
+// checkLogin checks an email and password for every form that takes them.
+// Throttled attempts get a *loginThrottledError and wrong ones
+// errBadCredentials, after counting against the throttles. Unknown emails
+// still pay for a password comparison, so they take as long to refuse as
+// a wrong password. A right password clears the account's failures and
+// upgrades a hash made with weaker parameters.
+func (cfg *apiConfig) checkLogin(ctx context.Context, r *http.Request, email, password string) (database.User, error) {
+	wait, err := cfg.loginWait(ctx, r, email)
+	if err != nil {
+		return database.User{}, err
+	}
+	if wait > 0 {
+		return database.User{}, &loginThrottledError{wait}
+	}
+
+	var found *database.User
+	hash := ""
+	user, err := cfg.queries.GetUserByEmail(ctx, email)
+	if err == nil {
+		found = &user
+		hash = user.HashedPassword
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if !auth.ComparePassword(password, hash) {
+		cfg.recordLoginFailure(ctx, r, email, found)
+		return database.User{}, errBadCredentials
+	}
+	cfg.clearLoginFailures(ctx, email)
+	if auth.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(ctx, user, password)
+	}
+	return user, nil
+}
+
+// setRetryAfter tells the client how many whole seconds to wait.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// respondWithLoginWait answers a throttled login. It's the same whether or
+// not the email has an account.
+func respondWithLoginWait(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	respondWithError(w, 429, "Too many failed login attempts, try again later")
+}
+
+// recordLoginFailure counts a failed password or second factor against
+// email and r's address, locking whichever has failed too often. user is
+// nil when the email has no account; otherwise its owner hears about a
+// lockout.
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, r *http.Request, email string, user *database.User) {
+	now := time.Now().UTC()
+	for _, key := range []string{accountThrottleKey(email), ipThrottleKey(clientIP(r))} {
+		t, err := cfg.queries.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+			ThrottleKey: key,
+			FailedAt:    now,
+			WindowStart: now.Add(-loginFailureWindow),
+		})
+		if err != nil {
+			log.Printf("couldn't record login failure: %s", err)
+			continue
+		}
+		if t.Failures < loginPolicyFor(key).lockAt {
+			continue
+		}
+		n, err := cfg.queries.LockLogin(ctx, database.LockLoginParams{
+			LockedUntil: now.Add(loginLockout),
+			ThrottleKey: key,
+			Now:         now,
+		})
+		if err != nil {
+			log.Printf("couldn't lock logins: %s", err)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		log.Printf("locked logins for %s after %d failures", key, t.Failures)
+		if user != nil && key == accountThrottleKey(email) {
+			cfg.recordSecurityEvent(ctx, r, user.ID, SecurityLoginLockout, strconv.Itoa(int(t.Failures))+" failed attempts")
+			go cfg.sendLockoutEmail(*user)
+		}
+	}
+}
+
+// clearLoginFailures forgets an account's failures after it logs in. The
+// address keeps its count, or logging into an account of one's own would
+// reset it.
+func (cfg *apiConfig) clearLoginFailures(ctx context.Context, email string) {
+	if err := cfg.queries.ClearLoginThrottle(ctx, accountThrottleKey(email)); err != nil {
+		log.Printf("couldn't clear login failures: %s", err)
+	}
+}
+
+func (cfg *apiConfig) sendLockoutEmail(user database.User) {
+	err := cfg.mailer.Send(context.Background(), mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account is temporarily locked",
+		Body: "There were too many failed attempts to sign in to your Chirpy account, " +
+			"so signing in is blocked for the next 15 minutes.\n\n" +
+			"If it wasn't you, someone may be guessing your password. " +
+			"Consider changing it once the lock ends.\n",
+	})
+	if err != nil {
+		log.Printf("couldn't send lockout email: %s", err)
+	}
+}
+
+// adminUnlockUser lifts a lockout on an account and forgets its failures.
+func (cfg *apiConfig) adminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.adminUser(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.queries.GetUserByID(context.Background(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "No user with that id")
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.queries.ClearLoginThrottle(context.Background(), accountThrottleKey(user.Email))
+	if err != nil {
+		log.Printf("couldn't clear login failures: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	cfg.recordSecurityEvent(context.Background(), r, userID, SecurityLoginUnlockedByAdmin, "")
+	w.WriteHeader(204)
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -18,6 +20,10 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/tnaums/chirpy/internal/auth"
 	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/mail"
+	"github.com/tnaums/chirpy/internal/oidc"
+	"github.com/tnaums/chirpy/internal/ratelimit"
+	"github.com/tnaums/chirpy/internal/stream"
 )
 
 type User struct {
@@ -25,9 +31,11 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	Email        string    `json:"email"`
+	Handle       string    `json:"handle"`
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	EmailVerified bool     `json:"email_verified"`
 }
 
 type Chirp struct {
@@ -38,6 +46,16 @@ type Chirp struct {
 	UserID    uuid.UUID `json:"user_id"`
 }
 
+func chirpFromDB(c database.Chirp) Chirp {
+	return Chirp{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Body:      c.Body,
+		UserID:    c.UserID,
+	}
+}
+
 func helloHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("And a good day to you!\n"))
 }
@@ -139,12 +157,45 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
 	w.Write([]byte(dat))
 }
 
+func respondWithBody(w http.ResponseWriter, code int, payload interface{}) {
+	dat, err := json.MarshalIndent(payload, "", " ")
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(dat)
+}
+
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	queries        *database.Queries
+	broker         *stream.Broker
+	wsHub          *wsHub
 	platform       string
 	secretPhrase   string
 	polkakey       string	
+	adminkey       string
+	mailer         mail.Mailer
+	baseURL        string
+	// requireVerifiedEmail blocks chirping until the user verifies their email
+	requireVerifiedEmail bool
+	// oidcProviders are the external providers users can sign in with, by name
+	oidcProviders map[string]oidc.Provider
+	// apiKeyLimiter enforces each personal API key's rate limit
+	apiKeyLimiter *ratelimit.Limiter
+	// jwtKeys signs and verifies access tokens; secretPhrase still signs
+	// the single-purpose tokens only Chirpy reads
+	jwtKeys *auth.Keyring
+	// passwordPolicy decides which new passwords are accepted
+	passwordPolicy auth.PasswordPolicy
+	// webhookClient delivers webhooks, only to public addresses outside dev
+	webhookClient *http.Client
+	// signingKeyKEK encrypts signing keys' private halves in the database
+	signingKeyKEK []byte
 }
 
 func (cfg *apiConfig) reportMetrics(w http.ResponseWriter, r *http.Request) {
@@ -170,10 +221,13 @@ func (cfg *apiConfig) resetUsers(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
 		log.Printf("Operation not allowed")
 		w.WriteHeader(403)
+		return
 	}
 	err := cfg.queries.DeleteUsers(context.Background())
 	if err != nil {
-		log.Printf("couldn't delete users: %w", err)
+		log.Printf("couldn't delete users: %s", err)
+		w.WriteHeader(500)
+		return
 	}
 	w.Write([]byte("Database reset successfully!\n"))
 }
@@ -186,6 +240,46 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+// authenticate returns the user id carried by the request's access token.
+func (cfg *apiConfig) authenticate(r *http.Request) (uuid.UUID, error) {
+	claims, err := cfg.accessClaims(r)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+// accessClaims is authenticate for endpoints that need more of the token
+// than the user id.
+func (cfg *apiConfig) accessClaims(r *http.Request) (*auth.AccessClaims, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkAccessToken(context.Background(), claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// viewerID is like authenticate but for endpoints that also serve anonymous
+// requests; it returns uuid.Nil when no valid access token is present. Apps
+// need the chirps:read scope to see what the user sees.
+func (cfg *apiConfig) viewerID(r *http.Request) uuid.UUID {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.Nil
+	}
+	id, err := cfg.authorize(r, auth.ScopeChirpsRead)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
 func (cfg *apiConfig) chirpById(w http.ResponseWriter, r *http.Request) {
 	fmt.Println(r.URL.Path)
 	id := r.PathValue("chirpID")
@@ -194,7 +288,12 @@ func (cfg *apiConfig) chirpById(w http.ResponseWriter, r *http.Request) {
 		log.Printf("couldn't create uid from id")
 	}
 
-	c, err := cfg.queries.ChirpByID(context.Background(), uid)
+	// chirps from blocked, muted or unfollowed private authors look like
+	// they don't exist
+	c, err := cfg.queries.VisibleChirpByID(context.Background(), database.VisibleChirpByIDParams{
+		ID:       uid,
+		ViewerID: cfg.viewerID(r),
+	})
 	if err != nil {
 		log.Printf("Error retrieving chirp by id: %s", err)
 		w.WriteHeader(404)
@@ -230,11 +329,8 @@ func (cfg *apiConfig) chirpDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get userid from access token
-	token, _ := auth.GetBearerToken(r.Header)
-	tokenid, err := auth.ValidateJWT(token, cfg.secretPhrase)
-	if err != nil {
-		log.Printf("token is invalid: %s", tokenid)
-		w.WriteHeader(401)
+	tokenid, ok := cfg.authorizeRequest(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
 
@@ -259,6 +355,12 @@ func (cfg *apiConfig) chirpDelete(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(404)
 		return
 	}
+	cfg.publishChirpEvent(context.Background(), stream.EventChirpDeleted, c)
+	cfg.enqueueWebhook(context.Background(), c.UserID, WebhookChirpDeleted, chirpEventData(stream.Event{
+		Type:     stream.EventChirpDeleted,
+		ChirpID:  c.ID,
+		AuthorID: c.UserID,
+	}))
 	w.WriteHeader(204)
 
 }
@@ -276,15 +378,19 @@ func (cfg *apiConfig) chirpGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id, _ := uuid.Parse(s)
+	viewer := cfg.viewerID(r)
 
 	if s == "" {
-		allChirps, err := cfg.queries.ListChirps(context.Background())
+		allChirps, err := cfg.queries.ListChirps(context.Background(), viewer)
 		functionChirps = append(functionChirps, allChirps...)
 		if err != nil {
-			log.Printf("couldn't retrieve chirps: %w", err)
+			log.Printf("couldn't retrieve chirps: %s", err)
 		}
 	} else {
-		allChirps, err := cfg.queries.ListUserChirps(context.Background(), id)
+		allChirps, err := cfg.queries.ListUserChirps(context.Background(), database.ListUserChirpsParams{
+			UserID:   id,
+			ViewerID: viewer,
+		})
 		functionChirps = append(functionChirps, allChirps...)		
 		if err != nil {
 			log.Printf("couldn't retrieve chirps for user %s", s)
@@ -325,12 +431,8 @@ func (cfg *apiConfig) chirpGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) chirpSave(w http.ResponseWriter, r *http.Request) {
-	token, _ := auth.GetBearerToken(r.Header)
-	fmt.Printf("Token is: %s\n", token)
-	tokenid, err := auth.ValidateJWT(token, cfg.secretPhrase)
-	if err != nil {
-		log.Printf("token is invalid: %s", tokenid)
-		w.WriteHeader(401)
+	tokenid, ok := cfg.authorizeRequest(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
 
@@ -340,7 +442,7 @@ func (cfg *apiConfig) chirpSave(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err)
 		w.WriteHeader(500)
@@ -350,6 +452,17 @@ func (cfg *apiConfig) chirpSave(w http.ResponseWriter, r *http.Request) {
 	//	fmt.Println(params.UserID)
 	fmt.Println(params.Body)
 
+	verified, err := cfg.emailVerified(context.Background(), tokenid)
+	if err != nil {
+		log.Printf("couldn't check email verification: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !verified {
+		respondWithError(w, 403, "Verify your email address before chirping")
+		return
+	}
+
 	// validate that chirp is not too long
 	if len(params.Body) > 140 {
 		respondWithError(w, 400, "Chirp is too long")
@@ -362,7 +475,11 @@ func (cfg *apiConfig) chirpSave(w http.ResponseWriter, r *http.Request) {
 		UserID: tokenid,
 	})
 	if err != nil {
-		log.Printf("couldn't create feed follow: %w", err)
+		log.Printf("couldn't create feed follow: %s", err)
+	} else {
+		cfg.notifyMentions(context.Background(), newChirp)
+		cfg.publishChirpEvent(context.Background(), stream.EventChirpCreated, newChirp)
+		cfg.enqueueWebhook(context.Background(), newChirp.UserID, WebhookChirpCreated, chirpFromDB(newChirp))
 	}
 
 	mainChirp := Chirp{
@@ -399,45 +516,68 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// JWT expires in 1 hour
-	expire := time.Duration(3600) * time.Second
-
-	luser, err := cfg.queries.GetUserByEmail(context.Background(), params.Email)
-	if err != nil {
-		log.Println("Incorrect email or password")
-		w.WriteHeader(401)
+	luser, err := cfg.checkLogin(context.Background(), r, params.Email, params.Password)
+	var throttled *loginThrottledError
+	if errors.As(err, &throttled) {
+		respondWithLoginWait(w, throttled.wait)
 		return
 	}
-
-	check, err2 := auth.CheckPasswordHash(params.Password, luser.HashedPassword)
-	if check == false {
+	if errors.Is(err, errBadCredentials) {
 		log.Println("Incorrect email or password")
 		w.WriteHeader(401)
 		return
 	}
+	if err != nil {
+		log.Printf("couldn't check login: %s", err)
+		w.WriteHeader(500)
+		return
+	}
 
-	if err2 != nil {
-		log.Printf("Error checking password hash")
+	// the password alone isn't enough; finish in loginTwoFactor
+	if luser.TotpEnabledAt.Valid {
+		cfg.respondWithChallenge(w, luser)
 		return
 	}
 
+	cfg.completeLogin(w, r, luser)
+}
+
+// completeLogin starts a session for an authenticated user and responds
+// with its access and refresh tokens.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, luser database.User) {
+	// JWT expires in 1 hour
+	expire := time.Duration(3600) * time.Second
+
+	// every login starts a new session
+	sessionID := uuid.New()
+
 	// Create JWT
-	jwt, _ := auth.MakeJWT(luser.ID, cfg.secretPhrase, expire)
+	jwt, _ := auth.MakeAccessToken(auth.AccessClaims{
+		UserID:    luser.ID,
+		SessionID: sessionID,
+		Tier:      userTier(luser),
+	}, cfg.jwtKeys, expire)
 
 	// Create refresh token and store in database
 
 	// Generate the token
 	rt, err := auth.MakeRefreshToken()
 	if err != nil {
-		log.Printf("Error generating refresh token")
+		log.Printf("Error generating refresh token: %s", err)
+		w.WriteHeader(500)
+		return
 	}
 
 	exp := time.Now().AddDate(0, 0, 60)
 
 	_, err = cfg.queries.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
-		Token: rt,
+		TokenHash: auth.HashToken(rt),
 		UserID: luser.ID,
 		ExpiresAt: exp,
+		FamilyID: sessionID,
+		UserAgent: r.UserAgent(),
+		Ip: clientIP(r),
+		SessionStartedAt: time.Now(),
 	})
 	if err != nil {
 		log.Printf("Error saving refresh token")
@@ -448,9 +588,11 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:    luser.CreatedAt,
 		UpdatedAt:    luser.UpdatedAt,
 		Email:        luser.Email,
+		Handle:       luser.Handle,
 		Token:        jwt,
 		RefreshToken: rt,
 		IsChirpyRed: luser.IsChirpyRed,
+		EmailVerified: luser.EmailVerifiedAt.Valid,
 	}
 
 	dat, err := json.MarshalIndent(mainUser, "", " ")
@@ -469,6 +611,7 @@ func (cfg *apiConfig) registerUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Handle   string `json:"handle"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -480,10 +623,26 @@ func (cfg *apiConfig) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	if params.Handle == "" {
+		params.Handle = defaultHandle()
+	} else if err := validateHandle(params.Handle); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	if !cfg.checkPassword(w, params.Password) {
+		return
+	}
+
 	// change password from plain text to hashed version
 	hash, err := auth.HashPassword(params.Password)
 	if err != nil {
-		log.Printf("Error creating password hash: %w", err)
+		log.Printf("Error creating password hash: %s", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -491,19 +650,33 @@ func (cfg *apiConfig) registerUser(w http.ResponseWriter, r *http.Request) {
 	user, err := cfg.queries.CreateUser(context.Background(), database.CreateUserParams{
 		Email:          params.Email,
 		HashedPassword: hash,
+		Handle:         params.Handle,
 	})
 	if err != nil {
-		log.Printf("couldn't create user: %w", err)
+		if isUniqueViolation(err) {
+			respondWithError(w, 409, "Email or handle is already taken")
+			return
+		}
+		log.Printf("couldn't create user: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	// don't hold up registration on the mail server
+	go func() {
+		if err := cfg.sendVerificationEmail(context.Background(), user); err != nil {
+			log.Printf("couldn't send verification email: %s", err)
+		}
+	}()
+
 	mainUser := User{
 		ID:        user.ID,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 		Email:     user.Email,
+		Handle:    user.Handle,
 		IsChirpyRed: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
 	}
 
 	dat, err := json.MarshalIndent(mainUser, "", " ")
@@ -518,25 +691,28 @@ func (cfg *apiConfig) registerUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// refreshToken trades a refresh token for a new access token and rotates
+// the refresh token. Each token can be used once: presenting a revoked or
+// already rotated token means it was stolen or replayed, so the whole
+// family it belongs to is revoked.
 func (cfg *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		log.Printf("No refresh token found in header")
+		w.WriteHeader(401)
+		return
 	}
 
 	// Lookup refresh token in database
-	refreshTokenStruct, err := cfg.queries.GetRefreshToken(context.Background(), refreshToken)
+	refreshTokenStruct, err := cfg.queries.GetRefreshToken(context.Background(), auth.HashToken(refreshToken))
 	if err != nil {
-		log.Printf("refresh token not in database", err)
+		log.Printf("refresh token not in database: %s", err)
 		w.WriteHeader(401)
 		return
 	}
 
-	fmt.Println()
-	fmt.Println(refreshTokenStruct.RevokedAt.Valid)
-	fmt.Println()
 	if refreshTokenStruct.RevokedAt.Valid {
-		log.Printf("refresh token revoked")
+		cfg.revokeFamilyOnReuse(r, refreshTokenStruct)
 		w.WriteHeader(401)
 		return
 	}
@@ -547,25 +723,92 @@ func (cfg *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	expire := time.Duration(3600) * time.Second
-	jwt, _ := auth.MakeJWT(refreshTokenStruct.UserID, cfg.secretPhrase, expire)
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
 
-	type parameters struct {
-		Token string `json:"token"`
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Printf("couldn't start transaction: %s", err)
+		w.WriteHeader(500)
+		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
 
-	params := parameters{Token: jwt,}
-	
-	dat, err := json.MarshalIndent(params, "", " ")
+	rotated, err := qtx.RotateRefreshToken(context.Background(), database.RotateRefreshTokenParams{
+		TokenHash:  refreshTokenStruct.TokenHash,
+		ReplacedBy: sql.NullString{String: auth.HashToken(newRefreshToken), Valid: true},
+	})
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		log.Printf("couldn't rotate refresh token: %s", err)
 		w.WriteHeader(500)
 		return
 	}
-	
-	w.WriteHeader(200)
-	w.Header().Set("Content-Type", "application/json")	
-	w.Write([]byte(dat))
+	// a concurrent request rotated it first
+	if rotated == 0 {
+		tx.Rollback()
+		cfg.revokeFamilyOnReuse(r, refreshTokenStruct)
+		w.WriteHeader(401)
+		return
+	}
+	_, err = qtx.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		TokenHash:        auth.HashToken(newRefreshToken),
+		UserID:           refreshTokenStruct.UserID,
+		ExpiresAt:        time.Now().AddDate(0, 0, 60),
+		FamilyID:         refreshTokenStruct.FamilyID,
+		UserAgent:        r.UserAgent(),
+		Ip:               clientIP(r),
+		SessionStartedAt: refreshTokenStruct.SessionStartedAt,
+	})
+	if err != nil {
+		log.Printf("Error saving refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("couldn't commit refresh token rotation: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), refreshTokenStruct.UserID)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	expire := time.Duration(3600) * time.Second
+	jwt, _ := auth.MakeAccessToken(auth.AccessClaims{
+		UserID:    user.ID,
+		SessionID: refreshTokenStruct.FamilyID,
+		Tier:      userTier(user),
+	}, cfg.jwtKeys, expire)
+
+	type response struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	respondWithBody(w, 200, response{Token: jwt, RefreshToken: newRefreshToken})
+}
+
+// revokeFamilyOnReuse logs out every session descended from the same login
+// as a refresh token that was presented after it stopped being valid.
+func (cfg *apiConfig) revokeFamilyOnReuse(r *http.Request, t database.RefreshToken) {
+	err := cfg.queries.RevokeTokenFamily(context.Background(), t.FamilyID)
+	if err != nil {
+		log.Printf("couldn't revoke refresh token family: %s", err)
+	}
+	detail := "revoked refresh token presented"
+	if t.ReplacedBy.Valid {
+		detail = "rotated refresh token presented again"
+	}
+	cfg.recordSecurityEvent(context.Background(), r, t.UserID, SecurityRefreshTokenReuse,
+		detail+"; revoked token family "+t.FamilyID.String())
 }
 
 func (cfg *apiConfig) revokeRefresh(w http.ResponseWriter, r *http.Request) {
@@ -575,14 +818,14 @@ func (cfg *apiConfig) revokeRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Lookup refresh token in database
-	_, err = cfg.queries.GetRefreshToken(context.Background(), refreshToken)
+	refreshTokenStruct, err := cfg.queries.GetRefreshToken(context.Background(), auth.HashToken(refreshToken))
 	if err != nil {
-		log.Printf("refresh token not in database", err)
+		log.Printf("refresh token not in database: %s", err)
 		w.WriteHeader(401)
 		return
 	}
 	
-	err = cfg.queries.RevokeToken(context.Background(), refreshToken)
+	err = cfg.queries.RevokeToken(context.Background(), refreshTokenStruct.TokenHash)
 	if err != nil {
 		log.Printf("failed to revoke token")
 		return
@@ -590,24 +833,22 @@ func (cfg *apiConfig) revokeRefresh(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
 
 }
+// updateUser changes the email and password. Only the user themselves may,
+// never an app or API key, and they must give their current password.
 func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("No access token found in header")
-	}
-
 	// get the userid from token (tokenid)
-	tokenid, err := auth.ValidateJWT(token, cfg.secretPhrase)
+	tokenid, err := cfg.authenticate(r)
 	if err != nil {
-		log.Printf("token is invalid: %s", tokenid)
+		log.Printf("token is invalid: %s", err)
 		w.WriteHeader(401)
 		return
 	}
 
 	// decode password and/or email from body
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -619,10 +860,41 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	current, err := cfg.queries.GetUserByID(context.Background(), tokenid)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	_, err = cfg.checkLogin(context.Background(), r, current.Email, params.CurrentPassword)
+	var throttled *loginThrottledError
+	if errors.As(err, &throttled) {
+		respondWithLoginWait(w, throttled.wait)
+		return
+	}
+	if errors.Is(err, errBadCredentials) {
+		respondWithError(w, 403, "Current password is incorrect")
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't check login: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	if !cfg.checkPassword(w, params.Password) {
+		return
+	}
+
 	// change password from plain text to hashed version
 	hash, err := auth.HashPassword(params.Password)
 	if err != nil {
-		log.Printf("Error creating password hash: %w", err)
+		log.Printf("Error creating password hash: %s", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -634,17 +906,39 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		HashedPassword: hash,
 	})
 	if err != nil {
-		log.Printf("couldn't update user: %w", err)
+		log.Printf("couldn't update user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// the old password's access tokens stop working, this one's included
+	err = cfg.queries.RevokeUserTokens(context.Background(), database.RevokeUserTokensParams{
+		ValidAfter: time.Now().UTC(),
+		ID:         user.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke access tokens: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	// a changed email has to be verified again
+	if !user.EmailVerifiedAt.Valid {
+		go func() {
+			if err := cfg.sendVerificationEmail(context.Background(), user); err != nil {
+				log.Printf("couldn't send verification email: %s", err)
+			}
+		}()
+	}
+
 	mainUser := User{
 		ID:        user.ID,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 		Email:     user.Email,
+		Handle:    user.Handle,
 		IsChirpyRed: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
 	}
 
 	dat, err := json.MarshalIndent(mainUser, "", " ")
@@ -661,14 +955,15 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 
 func (cfg *apiConfig) webHooks(w http.ResponseWriter, r *http.Request) {
 
-	token, err := auth.GetBearerToken(r.Header)
+	// Polka sends "Authorization: ApiKey <key>"
+	token, err := auth.GetAPIKey(r.Header)
 	if err != nil {
 		log.Printf("No authorization token found")
 		w.WriteHeader(401)
 		return
 	}
 
-	if token != cfg.polkakey {
+	if cfg.polkakey == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.polkakey)) != 1 {
 		log.Printf("token does not match required key")
 		w.WriteHeader(401)
 		return
@@ -700,6 +995,7 @@ func (cfg *apiConfig) webHooks(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(404)
 		return
 	}
+	cfg.notify(context.Background(), params.Data.UserID, uuid.Nil, NotificationChirpyRed, uuid.Nil)
 	w.WriteHeader(204)
 }
 
@@ -712,6 +1008,26 @@ func main() {
 	pf := os.Getenv("PLATFORM")
 	secret := os.Getenv("SECRET")
 	polka := os.Getenv("POLKA_KEY")
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	mailer, err := mailerFromEnv()
+	if err != nil {
+		log.Fatalf("invalid mailer configuration: %v", err)
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	if err := setPasswordParamsFromEnv(); err != nil {
+		log.Fatalf("invalid argon2id parameters: %v", err)
+	}
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("invalid password policy: %v", err)
+	}
+	oidcProviders, err := oidcProvidersFromEnv(context.Background(), strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		log.Fatalf("invalid oidc configuration: %v", err)
+	}
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
 		log.Fatalf("error connecting to db: %v", err)
@@ -721,11 +1037,45 @@ func main() {
 	dbQueries := database.New(db)
 
 	config := apiConfig{
+		db:           db,
 		queries:      dbQueries,
+		broker:       stream.NewBroker(),
+		wsHub:        newWSHub(),
 		platform:     pf,
 		secretPhrase: secret,
 		polkakey:     polka,
+		adminkey:     adminKey,
+		mailer:       mailer,
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		requireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		oidcProviders: oidcProviders,
+		apiKeyLimiter: ratelimit.New(apiKeyRatePeriod),
+		jwtKeys:       auth.NewKeyring(secret),
+		passwordPolicy: passwordPolicy,
+		webhookClient:  newWebhookClient(pf),
+	}
+	if kek := os.Getenv("JWT_KEY_ENCRYPTION_KEY"); kek != "" {
+		config.signingKeyKEK, err = auth.ParseKeyEncryptionKey(kek)
+		if err != nil {
+			log.Fatalf("invalid JWT_KEY_ENCRYPTION_KEY: %v", err)
+		}
 	}
+	err = config.setupSigningKeys(context.Background(), os.Getenv("JWT_SIGNING_ALG"))
+	if err != nil {
+		log.Fatalf("couldn't set up JWT signing keys: %v", err)
+	}
+	recPeriod := defaultRecommendationsPeriod
+	if p := os.Getenv("RECOMMENDATIONS_INTERVAL"); p != "" {
+		recPeriod, err = time.ParseDuration(p)
+		if err != nil {
+			log.Fatalf("invalid RECOMMENDATIONS_INTERVAL: %v", err)
+		}
+	}
+	go config.recommendationsLoop(recPeriod)
+	go config.listenEvents(dbURL)
+	go config.webhookLoop()
+	go config.loginThrottlesLoop()
+
 	// use the http.NewServerMux() function to create an empty servemux
 	mux := http.NewServeMux()
 
@@ -748,6 +1098,83 @@ func main() {
 	updateuser := http.HandlerFunc(config.updateUser)
 	chirpdelete := http.HandlerFunc(config.chirpDelete)
 	webhooks := http.HandlerFunc(config.webHooks)
+	block := http.HandlerFunc(config.blockUser)
+	unblock := http.HandlerFunc(config.unblockUser)
+	mute := http.HandlerFunc(config.muteUser)
+	unmute := http.HandlerFunc(config.unmuteUser)
+	listblocks := http.HandlerFunc(config.listBlocks)
+	listmutes := http.HandlerFunc(config.listMutes)
+	getprofile := http.HandlerFunc(config.getProfile)
+	updateprofile := http.HandlerFunc(config.updateProfile)
+	searchusers := http.HandlerFunc(config.searchUsers)
+	follow := http.HandlerFunc(config.followUser)
+	unfollow := http.HandlerFunc(config.unfollowUser)
+	followrequests := http.HandlerFunc(config.listFollowRequests)
+	acceptrequest := http.HandlerFunc(config.acceptFollowRequest)
+	rejectrequest := http.HandlerFunc(config.rejectFollowRequest)
+	createlist := http.HandlerFunc(config.createList)
+	mylists := http.HandlerFunc(config.myLists)
+	getlist := http.HandlerFunc(config.getList)
+	updatelist := http.HandlerFunc(config.updateList)
+	deletelist := http.HandlerFunc(config.deleteList)
+	listmembers := http.HandlerFunc(config.listMembers)
+	addmember := http.HandlerFunc(config.addListMember)
+	removemember := http.HandlerFunc(config.removeListMember)
+	listtimeline := http.HandlerFunc(config.listTimeline)
+	subscribelist := http.HandlerFunc(config.subscribeList)
+	unsubscribelist := http.HandlerFunc(config.unsubscribeList)
+	recommendations := http.HandlerFunc(config.getRecommendations)
+	notifications := http.HandlerFunc(config.listNotifications)
+	readnotifications := http.HandlerFunc(config.readNotifications)
+	streamchirps := http.HandlerFunc(config.streamChirps)
+	ws := http.HandlerFunc(config.serveWS)
+	listsessions := http.HandlerFunc(config.listSessions)
+	revokesession := http.HandlerFunc(config.revokeSession)
+	revokeallsessions := http.HandlerFunc(config.revokeAllSessions)
+	adminlistsessions := http.HandlerFunc(config.adminListSessions)
+	adminrevokesession := http.HandlerFunc(config.adminRevokeSession)
+	adminrevokeallsessions := http.HandlerFunc(config.adminRevokeAllSessions)
+	logintwofactor := http.HandlerFunc(config.loginTwoFactor)
+	enrolltotp := http.HandlerFunc(config.enrollTOTP)
+	confirmtotp := http.HandlerFunc(config.confirmTOTP)
+	disabletotp := http.HandlerFunc(config.disableTOTP)
+	recoverycodes := http.HandlerFunc(config.regenerateRecoveryCodes)
+	forgotpassword := http.HandlerFunc(config.forgotPassword)
+	resetpassword := http.HandlerFunc(config.resetPassword)
+	resetpasswordform := http.HandlerFunc(config.resetPasswordForm)
+	submitresetpasswordform := http.HandlerFunc(config.submitResetPasswordForm)
+	verifyemail := http.HandlerFunc(config.verifyEmail)
+	resendverification := http.HandlerFunc(config.resendVerification)
+	createwebhook := http.HandlerFunc(config.createWebhook)
+	listwebhooks := http.HandlerFunc(config.listWebhooks)
+	deletewebhook := http.HandlerFunc(config.deleteWebhook)
+	enablewebhook := http.HandlerFunc(config.enableWebhook)
+	webhookdeliveries := http.HandlerFunc(config.listWebhookDeliveries)
+	createoauthapp := http.HandlerFunc(config.createOAuthApp)
+	listoauthapps := http.HandlerFunc(config.listOAuthApps)
+	deleteoauthapp := http.HandlerFunc(config.deleteOAuthApp)
+	listoauthgrants := http.HandlerFunc(config.listOAuthGrants)
+	revokeoauthgrant := http.HandlerFunc(config.revokeOAuthGrant)
+	oauthauthorize := http.HandlerFunc(config.oauthAuthorize)
+	oauthapprove := http.HandlerFunc(config.oauthApprove)
+	oauthtoken := http.HandlerFunc(config.oauthToken)
+	oauthrevoke := http.HandlerFunc(config.oauthRevoke)
+	oauthintrospect := http.HandlerFunc(config.oauthIntrospect)
+	oidclogin := http.HandlerFunc(config.oidcLogin)
+	oidclink := http.HandlerFunc(config.oidcLink)
+	oidccallback := http.HandlerFunc(config.oidcCallback)
+	listidentities := http.HandlerFunc(config.listIdentities)
+	unlinkidentity := http.HandlerFunc(config.unlinkIdentity)
+	createapikey := http.HandlerFunc(config.createAPIKey)
+	listapikeys := http.HandlerFunc(config.listAPIKeys)
+	revokeapikey := http.HandlerFunc(config.revokeAPIKey)
+	jwks := http.HandlerFunc(config.jwks)
+	adminlistsigningkeys := http.HandlerFunc(config.adminListSigningKeys)
+	adminrotatesigningkeys := http.HandlerFunc(config.adminRotateSigningKeys)
+	adminunlockuser := http.HandlerFunc(config.adminUnlockUser)
+	adminlistroles := http.HandlerFunc(config.adminListRoles)
+	admingrantrole := http.HandlerFunc(config.adminGrantRole)
+	adminrevokerole := http.HandlerFunc(config.adminRevokeRole)
 	// Use the http.FileServer() function to create a handler
 	//	fs := http.FileServer(http.Dir(filepathRoot))
 	rh := http.RedirectHandler("http://example.org", 307)
@@ -756,8 +1183,8 @@ func main() {
 	mux.Handle("/time", th)
 	mux.Handle("GET /api/healthz", re)
 	mux.Handle("/hello", hw)
-	mux.Handle("GET /admin/metrics", rm)
-	mux.Handle("POST /admin/reset", resetdb)
+	mux.Handle("GET /admin/metrics", config.requirePermission(auth.PermMetricsRead, rm))
+	mux.Handle("POST /admin/reset", config.requirePermission(auth.PermDatabaseReset, resetdb))
 	mux.Handle("POST /api/validate_chirp", valchirp)
 	mux.Handle("POST /api/users", ru)
 	mux.Handle("PUT /api/users", updateuser)
@@ -769,9 +1196,86 @@ func main() {
 	mux.Handle("POST /api/refresh", refresh)
 	mux.Handle("POST /api/revoke", revoke)
 	mux.Handle("POST /api/polka/webhooks", webhooks)
+	mux.Handle("POST /api/users/{userID}/block", block)
+	mux.Handle("DELETE /api/users/{userID}/block", unblock)
+	mux.Handle("POST /api/users/{userID}/mute", mute)
+	mux.Handle("DELETE /api/users/{userID}/mute", unmute)
+	mux.Handle("GET /api/blocks", listblocks)
+	mux.Handle("GET /api/mutes", listmutes)
+	mux.Handle("GET /api/users/{idOrHandle}", getprofile)
+	mux.Handle("PATCH /api/users/me/profile", updateprofile)
+	mux.Handle("GET /api/users/search", searchusers)
+	mux.Handle("GET /api/users/recommendations", recommendations)
+	mux.Handle("POST /api/users/{userID}/follow", follow)
+	mux.Handle("DELETE /api/users/{userID}/follow", unfollow)
+	mux.Handle("GET /api/follow_requests", followrequests)
+	mux.Handle("POST /api/follow_requests/{userID}/accept", acceptrequest)
+	mux.Handle("POST /api/follow_requests/{userID}/reject", rejectrequest)
+	mux.Handle("POST /api/lists", createlist)
+	mux.Handle("GET /api/lists", mylists)
+	mux.Handle("GET /api/lists/{listID}", getlist)
+	mux.Handle("PATCH /api/lists/{listID}", updatelist)
+	mux.Handle("DELETE /api/lists/{listID}", deletelist)
+	mux.Handle("GET /api/lists/{listID}/members", listmembers)
+	mux.Handle("PUT /api/lists/{listID}/members/{userID}", addmember)
+	mux.Handle("DELETE /api/lists/{listID}/members/{userID}", removemember)
+	mux.Handle("GET /api/lists/{listID}/timeline", listtimeline)
+	mux.Handle("POST /api/lists/{listID}/subscription", subscribelist)
+	mux.Handle("DELETE /api/lists/{listID}/subscription", unsubscribelist)
+	mux.Handle("GET /api/notifications", notifications)
+	mux.Handle("POST /api/notifications/read", readnotifications)
+	mux.Handle("GET /api/stream/chirps", streamchirps)
+	mux.Handle("GET /api/ws", ws)
+	mux.Handle("POST /api/login/2fa", logintwofactor)
+	mux.Handle("POST /api/2fa/enroll", enrolltotp)
+	mux.Handle("POST /api/2fa/confirm", confirmtotp)
+	mux.Handle("POST /api/2fa/disable", disabletotp)
+	mux.Handle("POST /api/2fa/recovery-codes", recoverycodes)
+	mux.Handle("GET /api/sessions", listsessions)
+	mux.Handle("DELETE /api/sessions/{sessionID}", revokesession)
+	mux.Handle("POST /api/sessions/revoke-all", revokeallsessions)
+	mux.Handle("GET /admin/users/{userID}/sessions", config.requirePermission(auth.PermUsersManage, adminlistsessions))
+	mux.Handle("DELETE /admin/users/{userID}/sessions/{sessionID}", config.requirePermission(auth.PermUsersManage, adminrevokesession))
+	mux.Handle("POST /admin/users/{userID}/sessions/revoke-all", config.requirePermission(auth.PermUsersManage, adminrevokeallsessions))
+	mux.Handle("POST /api/password/forgot", forgotpassword)
+	mux.Handle("POST /api/password/reset", resetpassword)
+	mux.Handle("GET /password/reset", resetpasswordform)
+	mux.Handle("POST /password/reset", submitresetpasswordform)
+	mux.Handle("GET /api/users/verify", verifyemail)
+	mux.Handle("POST /api/users/verify/resend", resendverification)
+	mux.Handle("POST /api/webhooks", createwebhook)
+	mux.Handle("GET /api/webhooks", listwebhooks)
+	mux.Handle("DELETE /api/webhooks/{webhookID}", deletewebhook)
+	mux.Handle("POST /api/webhooks/{webhookID}/enable", enablewebhook)
+	mux.Handle("GET /api/webhooks/{webhookID}/deliveries", webhookdeliveries)
+	mux.Handle("POST /api/oauth/apps", createoauthapp)
+	mux.Handle("GET /api/oauth/apps", listoauthapps)
+	mux.Handle("DELETE /api/oauth/apps/{clientID}", deleteoauthapp)
+	mux.Handle("GET /api/oauth/grants", listoauthgrants)
+	mux.Handle("DELETE /api/oauth/grants/{clientID}", revokeoauthgrant)
+	mux.Handle("GET /oauth/authorize", oauthauthorize)
+	mux.Handle("POST /oauth/authorize", oauthapprove)
+	mux.Handle("POST /oauth/token", oauthtoken)
+	mux.Handle("POST /oauth/revoke", oauthrevoke)
+	mux.Handle("POST /oauth/introspect", oauthintrospect)
+	mux.Handle("GET /api/oidc/{provider}/login", oidclogin)
+	mux.Handle("POST /api/oidc/{provider}/link", oidclink)
+	mux.Handle("GET /api/oidc/{provider}/callback", oidccallback)
+	mux.Handle("GET /api/users/me/identities", listidentities)
+	mux.Handle("DELETE /api/users/me/identities/{provider}", unlinkidentity)
+	mux.Handle("POST /api/keys", createapikey)
+	mux.Handle("GET /api/keys", listapikeys)
+	mux.Handle("DELETE /api/keys/{keyID}", revokeapikey)
+	mux.Handle("GET /.well-known/jwks.json", jwks)
+	mux.Handle("GET /admin/signing-keys", config.requirePermission(auth.PermSigningKeys, adminlistsigningkeys))
+	mux.Handle("POST /admin/signing-keys/rotate", config.requirePermission(auth.PermSigningKeys, adminrotatesigningkeys))
+	mux.Handle("POST /admin/users/{userID}/unlock", config.requirePermission(auth.PermUsersManage, adminunlockuser))
+	mux.Handle("GET /admin/users/{userID}/roles", config.requirePermission(auth.PermRolesManage, adminlistroles))
+	mux.Handle("PUT /admin/users/{userID}/roles/{role}", config.requirePermission(auth.PermRolesManage, admingrantrole))
+	mux.Handle("DELETE /admin/users/{userID}/roles/{role}", config.requirePermission(auth.PermRolesManage, adminrevokerole))
 	s := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: config.middlewareAPIKeys(mux),
 	}
 
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
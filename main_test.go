@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolkaWebhookAuth(t *testing.T) {
+	cfg := &apiConfig{polkakey: "f271c81ff7084ee5b99a5091b42d486e"}
+	handler := cfg.middlewareAPIKeys(http.HandlerFunc(cfg.webHooks))
+
+	for _, tc := range []struct {
+		header string
+		want   int
+	}{
+		// ignored events are acknowledged without touching the database
+		{"ApiKey f271c81ff7084ee5b99a5091b42d486e", 204},
+		{"ApiKey wrong", 401},
+		{"Bearer f271c81ff7084ee5b99a5091b42d486e", 401},
+		{"", 401},
+	} {
+		body := strings.NewReader(`{"event":"user.payment_failed","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", body)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%q: expected %d but got %d", tc.header, tc.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+type NotificationKind string
+
+const (
+	NotificationFollow        NotificationKind = "follow"
+	NotificationFollowRequest NotificationKind = "follow_request"
+	NotificationMention       NotificationKind = "mention"
+	NotificationReply         NotificationKind = "reply"
+	NotificationLike          NotificationKind = "like"
+	NotificationChirpyRed     NotificationKind = "chirpy_red"
+)
+
+type Notification struct {
+	ID         uuid.UUID        `json:"id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	Kind       NotificationKind `json:"kind"`
+	Summary    string           `json:"summary"`
+	ActorID    *uuid.UUID       `json:"actor_id"`
+	ActorCount int32            `json:"actor_count"`
+	ChirpID    *uuid.UUID       `json:"chirp_id"`
+	Read       bool             `json:"read"`
+}
+
+// notificationEvent is the NOTIFY payload announcing a new or updated
+// notification to every instance.
+type notificationEvent struct {
+	UserID       uuid.UUID    `json:"user_id"`
+	Notification Notification `json:"notification"`
+}
+
+const (
+	notificationsChannel = "notifications"
+
+	defaultNotifications = 20
+	maxNotifications     = 100
+)
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9_]{3,30})`)
+
+// notificationSummary renders the one-line text clients show for a
+// notification, folding grouped events into "N people ...".
+func notificationSummary(kind NotificationKind, count int32) string {
+	who := "Someone"
+	if count > 1 {
+		who = fmt.Sprintf("%d people", count)
+	}
+	switch kind {
+	case NotificationFollow:
+		return who + " followed you"
+	case NotificationFollowRequest:
+		return who + " asked to follow you"
+	case NotificationMention:
+		return who + " mentioned you"
+	case NotificationReply:
+		return who + " replied to your chirp"
+	case NotificationLike:
+		return who + " liked your chirp"
+	case NotificationChirpyRed:
+		return "Welcome to Chirpy Red!"
+	}
+	return "You have a new notification"
+}
+
+func notificationFromDB(n database.Notification) Notification {
+	notification := Notification{
+		ID:         n.ID,
+		CreatedAt:  n.CreatedAt,
+		UpdatedAt:  n.UpdatedAt,
+		Kind:       NotificationKind(n.Kind),
+		Summary:    notificationSummary(NotificationKind(n.Kind), n.ActorCount),
+		ActorCount: n.ActorCount,
+		Read:       n.ReadAt.Valid,
+	}
+	if n.ActorID.Valid {
+		notification.ActorID = &n.ActorID.UUID
+	}
+	if n.ChirpID.Valid {
+		notification.ChirpID = &n.ChirpID.UUID
+	}
+	return notification
+}
+
+// notify records that actor did something to recipient. Events sharing a
+// group (every follow, or every like of one chirp) fold into the recipient's
+// unread notification for that group. Pass uuid.Nil for events without an
+// actor or chirp. Failures are logged, never returned: a missing
+// notification must not fail the request that triggered it.
+func (cfg *apiConfig) notify(ctx context.Context, recipient, actor uuid.UUID, kind NotificationKind, chirpID uuid.UUID) {
+	if actor == recipient {
+		return
+	}
+
+	if actor != uuid.Nil {
+		blocked, err := cfg.queries.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+			UserA: recipient,
+			UserB: actor,
+		})
+		if err != nil {
+			log.Printf("couldn't check blocks for notification: %s", err)
+			return
+		}
+		muted, err := cfg.queries.IsMuted(ctx, database.IsMutedParams{
+			MuterID: recipient,
+			MutedID: actor,
+		})
+		if err != nil {
+			log.Printf("couldn't check mutes for notification: %s", err)
+			return
+		}
+		if blocked || muted {
+			return
+		}
+	}
+
+	groupKey := string(kind)
+	if chirpID != uuid.Nil {
+		groupKey += ":" + chirpID.String()
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("couldn't start transaction: %s", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	// the upsert locks the group's row, so concurrent events for one group
+	// take turns and each recount sees the actors before it
+	n, err := qtx.CreateNotification(ctx, database.CreateNotificationParams{
+		UserID:   recipient,
+		Kind:     string(kind),
+		GroupKey: groupKey,
+		ActorID:  uuid.NullUUID{UUID: actor, Valid: actor != uuid.Nil},
+		ChirpID:  uuid.NullUUID{UUID: chirpID, Valid: chirpID != uuid.Nil},
+	})
+	if err != nil {
+		log.Printf("couldn't create %s notification: %s", kind, err)
+		return
+	}
+	if actor != uuid.Nil {
+		// actor_count is the number of different people, so liking a
+		// chirp twice counts once
+		err = qtx.AddNotificationActor(ctx, database.AddNotificationActorParams{
+			NotificationID: n.ID,
+			ActorID:        actor,
+		})
+		if err != nil {
+			log.Printf("couldn't add %s notification actor: %s", kind, err)
+			return
+		}
+		n, err = qtx.RecountNotificationActors(ctx, n.ID)
+		if err != nil {
+			log.Printf("couldn't count %s notification actors: %s", kind, err)
+			return
+		}
+	}
+
+	// let every instance push it to the recipient's live connections; the
+	// NOTIFY is delivered on commit
+	payload, err := json.Marshal(notificationEvent{UserID: recipient, Notification: notificationFromDB(n)})
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		return
+	}
+	err = qtx.NotifyNotification(ctx, string(payload))
+	if err != nil {
+		log.Printf("couldn't announce %s notification: %s", kind, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("couldn't commit %s notification: %s", kind, err)
+	}
+}
+
+// notifyMentions notifies every user @mentioned in a new chirp.
+func (cfg *apiConfig) notifyMentions(ctx context.Context, chirp database.Chirp) {
+	seen := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(chirp.Body, -1) {
+		handle := strings.ToLower(m[1])
+		if seen[handle] {
+			continue
+		}
+		seen[handle] = true
+
+		user, err := cfg.queries.GetUserByHandle(ctx, handle)
+		if err != nil {
+			continue
+		}
+		cfg.notify(ctx, user.ID, chirp.UserID, NotificationMention, chirp.ID)
+	}
+}
+
+// listNotifications pages newest first by when each notification was
+// created. Events folded into a notification later update it in place
+// without moving it, so pages never repeat or skip one.
+func (cfg *apiConfig) listNotifications(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	limit := defaultNotifications
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = min(n, maxNotifications)
+	}
+
+	// start past every possible row when there's no cursor
+	beforeTime := time.Now().Add(24 * time.Hour)
+	beforeID := uuid.Max
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		beforeTime, beforeID, err = decodeCursor(c)
+		if err != nil {
+			respondWithError(w, 400, "Invalid cursor")
+			return
+		}
+	}
+
+	rows, err := cfg.queries.ListNotifications(context.Background(), database.ListNotificationsParams{
+		UserID:     caller,
+		BeforeTime: beforeTime,
+		BeforeID:   beforeID,
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		log.Printf("couldn't list notifications: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	unread, err := cfg.queries.CountUnreadNotifications(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't count unread notifications: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		Notifications []Notification `json:"notifications"`
+		UnreadCount   int64          `json:"unread_count"`
+		NextCursor    string         `json:"next_cursor,omitempty"`
+	}
+	resp := response{Notifications: []Notification{}, UnreadCount: unread}
+	for _, n := range rows {
+		resp.Notifications = append(resp.Notifications, notificationFromDB(n))
+	}
+	if len(rows) == limit {
+		resp.NextCursor = encodeCursor(rows[len(rows)-1].CreatedAt, rows[len(rows)-1].ID)
+	}
+	respondWithBody(w, 200, resp)
+}
+
+func (cfg *apiConfig) readNotifications(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		IDs []uuid.UUID `json:"ids"`
+		All bool        `json:"all"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	if params.All {
+		err = cfg.queries.MarkAllNotificationsRead(context.Background(), caller)
+	} else {
+		err = cfg.queries.MarkNotificationsRead(context.Background(), database.MarkNotificationsReadParams{
+			UserID: caller,
+			Ids:    params.IDs,
+		})
+	}
+	if err != nil {
+		log.Printf("couldn't mark notifications read: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
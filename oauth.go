@@ -0,0 +1,926 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+const (
+	maxOAuthAppsPerUser = 10
+	maxRedirectURIs     = 10
+
+	oauthCodeTTL    = 10 * time.Minute
+	oauthAccessTTL  = time.Hour
+	oauthRefreshTTL = 60 * 24 * time.Hour
+)
+
+// OAuthApp is a registered third-party client. ClientSecret is only
+// returned when the app is created.
+type OAuthApp struct {
+	ID           uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+// OAuthGrant is an app a user has let into their account.
+type OAuthGrant struct {
+	ClientID  uuid.UUID `json:"client_id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func oauthAppFromDB(c database.OauthClient) OAuthApp {
+	return OAuthApp{
+		ID:           c.ID,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectUris,
+		Confidential: c.SecretHash.Valid,
+	}
+}
+
+// oauthError is an error response from the authorization or token endpoint
+// (RFC 6749 sections 4.1.2.1 and 5.2).
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, oerr oauthError) {
+	if code == 401 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithBody(w, code, oerr)
+}
+
+// authorize is authenticate for endpoints that third-party apps may call
+// with scope. Like every access token, an app token stops working as soon
+// as its token family is revoked, not just when it expires. A personal API key works the same
+// way, limited to the scopes it was created with.
+func (cfg *apiConfig) authorize(r *http.Request, scope string) (uuid.UUID, error) {
+	if key, ok := requestAPIKey(r); ok {
+		if scope == "" || !slices.Contains(key.Scopes, scope) {
+			return uuid.Nil, auth.ErrInsufficientScope
+		}
+		return key.UserID, nil
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	claims, err := auth.ValidateScopedJWT(token, cfg.jwtKeys, scope)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := cfg.checkAccessToken(context.Background(), claims); err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+// authorizeRequest is authorize for handlers: it answers 401, or 403 when
+// an app token lacks scope, and reports whether to carry on.
+func (cfg *apiConfig) authorizeRequest(w http.ResponseWriter, r *http.Request, scope string) (uuid.UUID, bool) {
+	id, err := cfg.authorize(r, scope)
+	if errors.Is(err, auth.ErrInsufficientScope) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		respondWithError(w, 403, "This app hasn't been granted "+scope)
+		return uuid.Nil, false
+	}
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// validateRedirectURI allows https, and plain http only on the loopback
+// interface for native apps (RFC 8252 section 7.3).
+func validateRedirectURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return "Invalid redirect uri " + raw
+	}
+	if u.Scheme == "https" {
+		return ""
+	}
+	if u.Scheme == "http" {
+		host := u.Hostname()
+		if host == "localhost" || net.ParseIP(host).IsLoopback() {
+			return ""
+		}
+	}
+	return "Redirect uri must use https: " + raw
+}
+
+func (cfg *apiConfig) createOAuthApp(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		respondWithError(w, 400, "App name must be 1 to 100 characters")
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxRedirectURIs {
+		respondWithError(w, 400, "Give between 1 and 10 redirect uris")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if msg := validateRedirectURI(uri); msg != "" {
+			respondWithError(w, 400, msg)
+			return
+		}
+	}
+
+	apps, err := cfg.queries.ListOAuthClients(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list oauth apps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if len(apps) >= maxOAuthAppsPerUser {
+		respondWithError(w, 400, "Too many apps")
+		return
+	}
+
+	// public clients, such as mobile apps, can't keep a secret
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			log.Printf("couldn't make client secret: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.queries.CreateOAuthClient(context.Background(), database.CreateOAuthClientParams{
+		OwnerID:      caller,
+		Name:         params.Name,
+		RedirectUris: params.RedirectURIs,
+		SecretHash:   secretHash,
+	})
+	if err != nil {
+		log.Printf("couldn't create oauth app: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	app := oauthAppFromDB(client)
+	app.ClientSecret = secret
+	respondWithBody(w, 201, app)
+}
+
+func (cfg *apiConfig) listOAuthApps(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	clients, err := cfg.queries.ListOAuthClients(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list oauth apps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	apps := []OAuthApp{}
+	for _, c := range clients {
+		apps = append(apps, oauthAppFromDB(c))
+	}
+	respondWithBody(w, 200, apps)
+}
+
+// deleteOAuthApp removes an app along with every grant and token issued to
+// it.
+func (cfg *apiConfig) deleteOAuthApp(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid client id")
+		return
+	}
+
+	n, err := cfg.queries.DeleteOAuthClient(context.Background(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: caller,
+	})
+	if err != nil {
+		log.Printf("couldn't delete oauth app: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "No app with that id")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) listOAuthGrants(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	rows, err := cfg.queries.ListOAuthGrants(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list oauth grants: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	grants := []OAuthGrant{}
+	for _, g := range rows {
+		grants = append(grants, OAuthGrant{
+			ClientID:  g.ClientID,
+			Name:      g.Name,
+			Scopes:    g.Scopes,
+			CreatedAt: g.CreatedAt,
+			UpdatedAt: g.UpdatedAt,
+		})
+	}
+	respondWithBody(w, 200, grants)
+}
+
+// revokeOAuthGrant takes back an app's access. Its tokens, including
+// access tokens that haven't expired yet, stop working immediately.
+func (cfg *apiConfig) revokeOAuthGrant(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid client id")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Printf("couldn't start transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	n, err := qtx.DeleteOAuthGrant(context.Background(), database.DeleteOAuthGrantParams{
+		ClientID: clientID,
+		UserID:   caller,
+	})
+	if err != nil {
+		log.Printf("couldn't delete oauth grant: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "That app doesn't have access to your account")
+		return
+	}
+	err = qtx.RevokeOAuthGrantTokens(context.Background(), database.RevokeOAuthGrantTokensParams{
+		ClientID: clientID,
+		UserID:   caller,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke oauth tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = qtx.DeleteOAuthCodes(context.Background(), database.DeleteOAuthCodesParams{
+		ClientID: clientID,
+		UserID:   caller,
+	})
+	if err != nil {
+		log.Printf("couldn't delete oauth codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("couldn't commit oauth grant revocation: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// authorizeParams is a validated authorization request.
+type authorizeParams struct {
+	Client      database.OauthClient
+	RedirectURI string
+	State       string
+	Scopes      []string
+	Challenge   string
+}
+
+// parseAuthorize validates an authorization request. Until the client and
+// redirect uri check out, errors are shown to the user rather than sent to
+// a redirect uri the app may not own; p.RedirectURI is empty in that case.
+func (cfg *apiConfig) parseAuthorize(ctx context.Context, q url.Values) (authorizeParams, *oauthError) {
+	p := authorizeParams{}
+	clientID, err := uuid.Parse(q.Get("client_id"))
+	if err != nil {
+		return p, &oauthError{"invalid_request", "Invalid client_id"}
+	}
+	p.Client, err = cfg.queries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return p, &oauthError{"invalid_request", "Unknown client_id"}
+	}
+	redirect := q.Get("redirect_uri")
+	for _, uri := range p.Client.RedirectUris {
+		if uri == redirect {
+			p.RedirectURI = uri
+		}
+	}
+	if p.RedirectURI == "" {
+		return p, &oauthError{"invalid_request", "redirect_uri isn't registered for this app"}
+	}
+
+	p.State = q.Get("state")
+	if q.Get("response_type") != "code" {
+		return p, &oauthError{"unsupported_response_type", "Only response_type=code is supported"}
+	}
+	p.Scopes, err = auth.ParseScopes(q.Get("scope"))
+	if err != nil {
+		return p, &oauthError{"invalid_scope", err.Error()}
+	}
+	p.Challenge = q.Get("code_challenge")
+	if q.Get("code_challenge_method") != "S256" || !auth.ValidPKCEChallenge(p.Challenge) {
+		return p, &oauthError{"invalid_request", "PKCE with code_challenge_method=S256 is required"}
+	}
+	return p, nil
+}
+
+// redirectToApp sends the browser back to the app with params added to its
+// redirect uri.
+func redirectToApp(w http.ResponseWriter, r *http.Request, p authorizeParams, params url.Values) {
+	u, _ := url.Parse(p.RedirectURI)
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if p.State != "" {
+		q.Set("state", p.State)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, p authorizeParams, oerr oauthError) {
+	redirectToApp(w, r, p, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.App}} - Chirpy</title>
+</head>
+<body>
+{{if .App}}
+<h1>{{.App}} wants to use your Chirpy account</h1>
+<p>If you allow it, {{.App}} will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Params}}
+<form method="post" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}
+<p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><label>Two-factor or recovery code, if you use 2FA <input name="code" autocomplete="one-time-code"></label></p>
+<p>
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// renderConsent shows the consent page. With a nil p it only shows msg, for
+// requests that can't be sent back to the app.
+func renderConsent(w http.ResponseWriter, code int, p *authorizeParams, q url.Values, email, msg string) {
+	data := struct {
+		App    string
+		Scopes []string
+		Params map[string]string
+		Email  string
+		Error  string
+	}{Email: email, Error: msg}
+	if p != nil {
+		data.App = p.Client.Name
+		for _, s := range p.Scopes {
+			data.Scopes = append(data.Scopes, auth.Scopes[s])
+		}
+		data.Params = map[string]string{}
+		for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method"} {
+			data.Params[name] = q.Get(name)
+		}
+	}
+
+	// the page takes a password, so nobody may frame it
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := consentPage.Execute(w, data); err != nil {
+		log.Printf("couldn't render consent page: %s", err)
+	}
+}
+
+// oauthAuthorize shows the consent page for an authorization request.
+func (cfg *apiConfig) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, oerr := cfg.parseAuthorize(context.Background(), q)
+	if oerr != nil && p.RedirectURI == "" {
+		renderConsent(w, 400, nil, q, "", oerr.Description)
+		return
+	}
+	if oerr != nil {
+		redirectWithOAuthError(w, r, p, *oerr)
+		return
+	}
+	renderConsent(w, 200, &p, q, "", "")
+}
+
+// oauthApprove handles the consent form. The user signs in on the form
+// itself, so the app never sees their password.
+func (cfg *apiConfig) oauthApprove(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderConsent(w, 400, nil, nil, "", "Invalid form")
+		return
+	}
+	q := r.PostForm
+	p, oerr := cfg.parseAuthorize(context.Background(), q)
+	if oerr != nil && p.RedirectURI == "" {
+		renderConsent(w, 400, nil, q, "", oerr.Description)
+		return
+	}
+	if oerr != nil {
+		redirectWithOAuthError(w, r, p, *oerr)
+		return
+	}
+	if q.Get("decision") != "allow" {
+		redirectWithOAuthError(w, r, p, oauthError{"access_denied", "The user denied the request"})
+		return
+	}
+
+	email := q.Get("email")
+	user, err := cfg.checkLogin(context.Background(), r, email, q.Get("password"))
+	var throttled *loginThrottledError
+	if errors.As(err, &throttled) {
+		setRetryAfter(w, throttled.wait)
+		renderConsent(w, 429, &p, q, email, "Too many failed sign-in attempts, try again later")
+		return
+	}
+	if errors.Is(err, errBadCredentials) {
+		renderConsent(w, 401, &p, q, email, "Incorrect email or password")
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't check login: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		code, recoveryCode := q.Get("code"), ""
+		if len(code) != auth.TOTPDigits {
+			code, recoveryCode = "", code
+		}
+		ok, err := cfg.checkSecondFactor(context.Background(), user, code, recoveryCode)
+		if err != nil {
+			log.Printf("couldn't check second factor: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if !ok {
+			cfg.recordLoginFailure(context.Background(), r, user.Email, &user)
+			renderConsent(w, 401, &p, q, email, "Enter a current two-factor or recovery code")
+			return
+		}
+	}
+
+	err = cfg.queries.UpsertOAuthGrant(context.Background(), database.UpsertOAuthGrantParams{
+		ClientID: p.Client.ID,
+		UserID:   user.ID,
+		Scopes:   p.Scopes,
+	})
+	if err != nil {
+		log.Printf("couldn't save oauth grant: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("couldn't make authorization code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.queries.CreateOAuthCode(context.Background(), database.CreateOAuthCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      p.Client.ID,
+		UserID:        user.ID,
+		RedirectUri:   p.RedirectURI,
+		Scopes:        p.Scopes,
+		CodeChallenge: p.Challenge,
+		FamilyID:      uuid.New(),
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		log.Printf("couldn't save authorization code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	redirectToApp(w, r, p, url.Values{"code": {code}})
+}
+
+// oauthClient authenticates the app calling the token, revocation or
+// introspection endpoint, from HTTP Basic auth or the form. Public clients
+// only give their client_id; confidential ones must give their secret.
+func (cfg *apiConfig) oauthClient(r *http.Request) (database.OauthClient, *oauthError) {
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, &oauthError{"invalid_client", "Invalid client_id"}
+	}
+	client, err := cfg.queries.GetOAuthClient(context.Background(), clientID)
+	if err != nil {
+		return database.OauthClient{}, &oauthError{"invalid_client", "Unknown client_id"}
+	}
+	if client.SecretHash.Valid {
+		hash := auth.HashToken(secret)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(client.SecretHash.String)) != 1 {
+			return database.OauthClient{}, &oauthError{"invalid_client", "Invalid client_secret"}
+		}
+	}
+	return client, nil
+}
+
+// oauthTokens is a successful token endpoint response (RFC 6749 section
+// 5.1).
+type oauthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// issueOAuthTokens makes an access token and a refresh token in family.
+func (cfg *apiConfig) issueOAuthTokens(ctx context.Context, q *database.Queries, family, clientID, userID uuid.UUID, scopes []string) (*oauthTokens, error) {
+	refresh, err := auth.MakeRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	err = q.CreateOAuthToken(ctx, database.CreateOAuthTokenParams{
+		TokenHash: auth.HashToken(refresh),
+		FamilyID:  family,
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(oauthRefreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	user, err := q.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	access, err := auth.MakeAccessToken(auth.AccessClaims{
+		UserID:    userID,
+		SessionID: family,
+		ClientID:  clientID,
+		Scopes:    scopes,
+		Tier:      userTier(user),
+	}, cfg.jwtKeys, oauthAccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &oauthTokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTTL.Seconds()),
+		RefreshToken: refresh,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// oauthToken is the token endpoint (RFC 6749 section 3.2). It redeems
+// authorization codes and rotates refresh tokens.
+func (cfg *apiConfig) oauthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, oauthError{"invalid_request", "Invalid form"})
+		return
+	}
+	client, oerr := cfg.oauthClient(r)
+	if oerr != nil {
+		respondWithOAuthError(w, 401, *oerr)
+		return
+	}
+
+	var tokens *oauthTokens
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		tokens, oerr = cfg.redeemOAuthCode(r, client)
+	case "refresh_token":
+		tokens, oerr = cfg.refreshOAuthToken(r, client)
+	default:
+		oerr = &oauthError{"unsupported_grant_type", "grant_type must be authorization_code or refresh_token"}
+	}
+	if oerr != nil && oerr.Code == "server_error" {
+		respondWithOAuthError(w, 500, *oerr)
+		return
+	}
+	if oerr != nil {
+		respondWithOAuthError(w, 400, *oerr)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithBody(w, 200, tokens)
+}
+
+var (
+	errOAuthServer       = &oauthError{"server_error", ""}
+	errOAuthInvalidGrant = &oauthError{"invalid_grant", "Invalid, expired or already used grant"}
+)
+
+// redeemOAuthCode exchanges an authorization code. A code is good once; if
+// it's presented again, whatever the first exchange issued is revoked in
+// case the code was stolen (RFC 6749 section 4.1.2).
+func (cfg *apiConfig) redeemOAuthCode(r *http.Request, client database.OauthClient) (*oauthTokens, *oauthError) {
+	ctx := context.Background()
+	code, err := cfg.queries.GetOAuthCode(ctx, auth.HashToken(r.PostForm.Get("code")))
+	if err != nil || code.ClientID != client.ID {
+		return nil, errOAuthInvalidGrant
+	}
+	if code.ExpiresAt.Before(time.Now()) || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+		return nil, errOAuthInvalidGrant
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		return nil, &oauthError{"invalid_grant", "code_verifier doesn't match code_challenge"}
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("couldn't start transaction: %s", err)
+		return nil, errOAuthServer
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	used, err := qtx.UseOAuthCode(ctx, code.CodeHash)
+	if err != nil {
+		log.Printf("couldn't use authorization code: %s", err)
+		return nil, errOAuthServer
+	}
+	if used == 0 {
+		tx.Rollback()
+		err = cfg.queries.RevokeOAuthFamily(ctx, code.FamilyID)
+		if err != nil {
+			log.Printf("couldn't revoke oauth token family: %s", err)
+		}
+		cfg.recordSecurityEvent(ctx, r, code.UserID, SecurityOAuthCodeReuse,
+			"authorization code for app "+client.ID.String()+" presented again; revoked token family "+code.FamilyID.String())
+		return nil, errOAuthInvalidGrant
+	}
+	// the user may have revoked the app since approving it
+	_, err = qtx.GetOAuthGrant(ctx, database.GetOAuthGrantParams{
+		ClientID: client.ID,
+		UserID:   code.UserID,
+	})
+	if err != nil {
+		return nil, errOAuthInvalidGrant
+	}
+
+	tokens, err := cfg.issueOAuthTokens(ctx, qtx, code.FamilyID, client.ID, code.UserID, code.Scopes)
+	if err != nil {
+		log.Printf("couldn't issue oauth tokens: %s", err)
+		return nil, errOAuthServer
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("couldn't commit authorization code exchange: %s", err)
+		return nil, errOAuthServer
+	}
+	return tokens, nil
+}
+
+// refreshOAuthToken rotates an app's refresh token the way refreshToken
+// does for first-party sessions, including revoking the family on reuse.
+func (cfg *apiConfig) refreshOAuthToken(r *http.Request, client database.OauthClient) (*oauthTokens, *oauthError) {
+	ctx := context.Background()
+	t, err := cfg.queries.GetOAuthToken(ctx, auth.HashToken(r.PostForm.Get("refresh_token")))
+	if err != nil || t.ClientID != client.ID {
+		return nil, errOAuthInvalidGrant
+	}
+	if t.ExpiresAt.Before(time.Now()) {
+		return nil, errOAuthInvalidGrant
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("couldn't start transaction: %s", err)
+		return nil, errOAuthServer
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	rotated, err := qtx.RotateOAuthToken(ctx, t.TokenHash)
+	if err != nil {
+		log.Printf("couldn't rotate oauth refresh token: %s", err)
+		return nil, errOAuthServer
+	}
+	if rotated == 0 {
+		tx.Rollback()
+		err = cfg.queries.RevokeOAuthFamily(ctx, t.FamilyID)
+		if err != nil {
+			log.Printf("couldn't revoke oauth token family: %s", err)
+		}
+		cfg.recordSecurityEvent(ctx, r, t.UserID, SecurityOAuthTokenReuse,
+			"revoked refresh token for app "+client.ID.String()+" presented; revoked token family "+t.FamilyID.String())
+		return nil, errOAuthInvalidGrant
+	}
+
+	tokens, err := cfg.issueOAuthTokens(ctx, qtx, t.FamilyID, client.ID, t.UserID, t.Scopes)
+	if err != nil {
+		log.Printf("couldn't issue oauth tokens: %s", err)
+		return nil, errOAuthServer
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("couldn't commit oauth refresh token rotation: %s", err)
+		return nil, errOAuthServer
+	}
+	return tokens, nil
+}
+
+// appTokenClaims parses one of client's access tokens, or returns nil.
+func (cfg *apiConfig) appTokenClaims(token string, client database.OauthClient) *auth.AccessClaims {
+	claims, err := auth.ValidateScopedJWT(token, cfg.jwtKeys, "")
+	if err != nil || claims.ClientID != client.ID {
+		return nil
+	}
+	return claims
+}
+
+// oauthRevoke is the revocation endpoint (RFC 7009). Revoking either kind
+// of token ends the whole token family. Unknown tokens aren't an error, so
+// the response gives nothing away.
+func (cfg *apiConfig) oauthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, oauthError{"invalid_request", "Invalid form"})
+		return
+	}
+	client, oerr := cfg.oauthClient(r)
+	if oerr != nil {
+		respondWithOAuthError(w, 401, *oerr)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	family := uuid.Nil
+	if t, err := cfg.queries.GetOAuthToken(context.Background(), auth.HashToken(token)); err == nil && t.ClientID == client.ID {
+		family = t.FamilyID
+	} else if claims := cfg.appTokenClaims(token, client); claims != nil {
+		family = claims.SessionID
+	}
+	if family != uuid.Nil {
+		err := cfg.queries.RevokeOAuthFamily(context.Background(), family)
+		if err != nil {
+			log.Printf("couldn't revoke oauth token family: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	w.WriteHeader(200)
+}
+
+// oauthIntrospect is the introspection endpoint (RFC 7662). Apps may only
+// introspect their own tokens; anything else is reported inactive.
+func (cfg *apiConfig) oauthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, oauthError{"invalid_request", "Invalid form"})
+		return
+	}
+	client, oerr := cfg.oauthClient(r)
+	if oerr != nil {
+		respondWithOAuthError(w, 401, *oerr)
+		return
+	}
+
+	type response struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Sub       string `json:"sub,omitempty"`
+		Exp       int64  `json:"exp,omitempty"`
+		Iat       int64  `json:"iat,omitempty"`
+		Jti       string `json:"jti,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	token := r.PostForm.Get("token")
+	if claims := cfg.appTokenClaims(token, client); claims != nil {
+		err := cfg.checkAccessToken(context.Background(), claims)
+		if errors.Is(err, errTokenRevoked) {
+			respondWithBody(w, 200, response{})
+			return
+		}
+		if err != nil {
+			log.Printf("couldn't check oauth access token: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		respondWithBody(w, 200, response{
+			Active:    true,
+			Scope:     strings.Join(claims.Scopes, " "),
+			ClientID:  claims.ClientID.String(),
+			Sub:       claims.UserID.String(),
+			Exp:       claims.ExpiresAt.Unix(),
+			Iat:       claims.IssuedAt.Unix(),
+			Jti:       claims.TokenID,
+			TokenType: "access_token",
+		})
+		return
+	}
+
+	t, err := cfg.queries.GetOAuthToken(context.Background(), auth.HashToken(token))
+	if err != nil || t.ClientID != client.ID || t.RevokedAt.Valid || t.ExpiresAt.Before(time.Now()) {
+		respondWithBody(w, 200, response{})
+		return
+	}
+	respondWithBody(w, 200, response{
+		Active:    true,
+		Scope:     strings.Join(t.Scopes, " "),
+		ClientID:  t.ClientID.String(),
+		Sub:       t.UserID.String(),
+		Exp:       t.ExpiresAt.Unix(),
+		Iat:       t.CreatedAt.Unix(),
+		TokenType: "refresh_token",
+	})
+}
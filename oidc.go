@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/oidc"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var (
+	errOIDCUnverifiedEmail = errors.New("The provider hasn't verified your email address")
+	errOIDCUnverifiedUser  = errors.New("An account with this email exists but its email isn't verified; sign in with your password and link the provider instead")
+	errOIDCLinkedElsewhere = errors.New("That provider account is linked to another Chirpy user")
+	errOIDCAlreadyLinked   = errors.New("You already linked an account from this provider; unlink it first")
+)
+
+// Identity is an external account a user can sign in with.
+type Identity struct {
+	Provider    string    `json:"provider"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+func identityFromDB(i database.UserIdentity) Identity {
+	return Identity{
+		Provider:    i.Provider,
+		Email:       i.Email,
+		CreatedAt:   i.CreatedAt,
+		LastLoginAt: i.LastLoginAt,
+	}
+}
+
+// hasPassword reports whether the user can sign in with a password. Users
+// who signed up through a provider have none until they set one with the
+// password reset flow.
+func hasPassword(u database.User) bool {
+	return strings.HasPrefix(u.HashedPassword, "$argon2id$")
+}
+
+// oidcProvidersFromEnv sets up the provider configured by OIDC_ISSUER,
+// OIDC_CLIENT_ID and OIDC_CLIENT_SECRET, named OIDC_PROVIDER in its URLs.
+// Sign-in with a provider is off when OIDC_ISSUER is unset.
+func oidcProvidersFromEnv(ctx context.Context, baseURL string) (map[string]oidc.Provider, error) {
+	providers := map[string]oidc.Provider{}
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return providers, nil
+	}
+	name := os.Getenv("OIDC_PROVIDER")
+	if name == "" {
+		name = "oidc"
+	}
+	if !providerName.MatchString(name) {
+		return nil, fmt.Errorf("invalid OIDC_PROVIDER %q", name)
+	}
+
+	client, err := oidc.Discover(ctx, oidc.Config{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  baseURL + "/api/oidc/" + name + "/callback",
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	providers[name] = client
+	return providers, nil
+}
+
+func (cfg *apiConfig) oidcProvider(w http.ResponseWriter, r *http.Request) (string, oidc.Provider, bool) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, 404, "Unknown sign-in provider")
+		return "", nil, false
+	}
+	return name, provider, true
+}
+
+// startOIDC records a sign-in and returns the provider URL to send the user
+// to. The state, nonce and PKCE verifier stay on our side; the provider only
+// sees the state and a hash of the verifier.
+func (cfg *apiConfig) startOIDC(ctx context.Context, name string, provider oidc.Provider, linkTo uuid.NullUUID) (string, error) {
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := cfg.queries.DeleteExpiredOIDCLogins(ctx); err != nil {
+		log.Printf("couldn't delete expired oidc logins: %s", err)
+	}
+	err = cfg.queries.CreateOIDCLogin(ctx, database.CreateOIDCLoginParams{
+		StateHash:    auth.HashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserID:       linkTo,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return provider.AuthURL(state, nonce, auth.PKCEChallenge(verifier)), nil
+}
+
+// oidcLogin sends the browser to the provider to sign in.
+func (cfg *apiConfig) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	authURL, err := cfg.startOIDC(context.Background(), name, provider, uuid.NullUUID{})
+	if err != nil {
+		log.Printf("couldn't start oidc login: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcLink starts linking a provider account to the caller. Open the
+// returned URL in a browser; the callback finishes the link.
+func (cfg *apiConfig) oidcLink(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	name, provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	authURL, err := cfg.startOIDC(context.Background(), name, provider, uuid.NullUUID{UUID: caller, Valid: true})
+	if err != nil {
+		log.Printf("couldn't start oidc link: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	respondWithBody(w, 200, response{AuthorizationURL: authURL})
+}
+
+// oidcCallback is where the provider sends the browser back. It either
+// links the provider account or signs in, the same as loginUser.
+func (cfg *apiConfig) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	login, err := cfg.queries.TakeOIDCLogin(context.Background(), auth.HashToken(q.Get("state")))
+	if err != nil || login.Provider != name || login.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, 400, "Invalid or expired sign-in, start again")
+		return
+	}
+	if q.Get("error") != "" {
+		respondWithError(w, 401, "Sign-in was cancelled at the provider")
+		return
+	}
+
+	ident, err := provider.Exchange(context.Background(), q.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("couldn't verify %s sign-in: %s", name, err)
+		respondWithError(w, 401, "Couldn't verify the sign-in with the provider")
+		return
+	}
+
+	if login.UserID.Valid {
+		identity, err := cfg.linkIdentity(context.Background(), name, ident, login.UserID.UUID)
+		if err != nil {
+			cfg.respondWithOIDCError(w, err)
+			return
+		}
+		respondWithBody(w, 200, identityFromDB(identity))
+		return
+	}
+
+	user, err := cfg.oidcUser(context.Background(), name, ident)
+	if err != nil {
+		cfg.respondWithOIDCError(w, err)
+		return
+	}
+	// the provider stands in for the password, not the second factor
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithChallenge(w, user)
+		return
+	}
+	cfg.completeLogin(w, r, user)
+}
+
+func (cfg *apiConfig) respondWithOIDCError(w http.ResponseWriter, err error) {
+	switch err {
+	case errOIDCUnverifiedEmail:
+		respondWithError(w, 403, err.Error())
+	case errOIDCUnverifiedUser, errOIDCLinkedElsewhere, errOIDCAlreadyLinked:
+		respondWithError(w, 409, err.Error())
+	default:
+		log.Printf("couldn't finish oidc sign-in: %s", err)
+		w.WriteHeader(500)
+	}
+}
+
+// linkIdentity links a provider account to userID. Linking the same account
+// again is a no-op.
+func (cfg *apiConfig) linkIdentity(ctx context.Context, name string, ident oidc.Identity, userID uuid.UUID) (database.UserIdentity, error) {
+	existing, err := cfg.queries.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: name,
+		Subject:  ident.Subject,
+	})
+	if err == nil {
+		if existing.UserID != userID {
+			return database.UserIdentity{}, errOIDCLinkedElsewhere
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.UserIdentity{}, err
+	}
+
+	identity, err := cfg.queries.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: name,
+		Subject:  ident.Subject,
+		UserID:   userID,
+		Email:    ident.Email,
+	})
+	if isUniqueViolation(err) {
+		return database.UserIdentity{}, errOIDCAlreadyLinked
+	}
+	return identity, err
+}
+
+// oidcUser finds the user a provider sign-in belongs to. An unknown
+// provider account is linked to the user with the same email, but only if
+// both sides have verified that email; otherwise whoever registered the
+// address first could take over the other's account. Failing that, a new
+// user without a password is created.
+func (cfg *apiConfig) oidcUser(ctx context.Context, name string, ident oidc.Identity) (database.User, error) {
+	identity, err := cfg.queries.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: name,
+		Subject:  ident.Subject,
+	})
+	if err == nil {
+		err = cfg.queries.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+			Provider: name,
+			Subject:  ident.Subject,
+			Email:    ident.Email,
+		})
+		if err != nil {
+			log.Printf("couldn't update identity: %s", err)
+		}
+		return cfg.queries.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if !ident.EmailVerified {
+		return database.User{}, errOIDCUnverifiedEmail
+	}
+	user, err := cfg.queries.GetUserByEmail(ctx, ident.Email)
+	if err == nil {
+		if !user.EmailVerifiedAt.Valid {
+			return database.User{}, errOIDCUnverifiedUser
+		}
+		_, err = cfg.linkIdentity(ctx, name, ident, user.ID)
+		return user, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+		Email:          ident.Email,
+		HashedPassword: "",
+		Handle:         defaultHandle(),
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	_, err = qtx.MarkEmailVerified(ctx, database.MarkEmailVerifiedParams{
+		ID:    user.ID,
+		Email: user.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: name,
+		Subject:  ident.Subject,
+		UserID:   user.ID,
+		Email:    ident.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+	return cfg.queries.GetUserByID(ctx, user.ID)
+}
+
+func (cfg *apiConfig) listIdentities(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	rows, err := cfg.queries.ListUserIdentities(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list identities: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	identities := []Identity{}
+	for _, i := range rows {
+		identities = append(identities, identityFromDB(i))
+	}
+	respondWithBody(w, 200, identities)
+}
+
+// unlinkIdentity removes a provider account from the caller, unless it's
+// the only way they have left to sign in.
+func (cfg *apiConfig) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !hasPassword(user) {
+		count, err := cfg.queries.CountUserIdentities(context.Background(), caller)
+		if err != nil {
+			log.Printf("couldn't count identities: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if count <= 1 {
+			respondWithError(w, 409, "Set a password before unlinking your only sign-in provider")
+			return
+		}
+	}
+
+	n, err := cfg.queries.DeleteUserIdentity(context.Background(), database.DeleteUserIdentityParams{
+		UserID:   caller,
+		Provider: r.PathValue("provider"),
+	})
+	if err != nil {
+		log.Printf("couldn't unlink identity: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "No linked account from that provider")
+		return
+	}
+	w.WriteHeader(204)
+}
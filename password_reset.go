@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/mail"
+)
+
+const (
+	passwordResetTTL       = 30 * time.Minute
+	maxPasswordResetsPerHr = 3
+
+	// the form the reset email links to; API clients post the token to
+	// /api/password/reset instead
+	passwordResetPath = "/password/reset"
+)
+
+// sendPasswordReset emails user a short-lived, single-use reset link.
+// Only a hash of the link's token is stored.
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, user database.User) error {
+	sent, err := cfg.queries.CountPasswordResetsSince(ctx, database.CountPasswordResetsSinceParams{
+		UserID:    user.ID,
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		return err
+	}
+	// the caller always answers 202, so quietly stop mail-bombing here
+	if sent >= maxPasswordResetsPerHr {
+		log.Printf("password reset limit reached for %s", user.ID)
+		return nil
+	}
+
+	token, err := auth.MakeSignedToken(user.ID, auth.TokenTypePasswordReset, cfg.secretPhrase, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	err = cfg.queries.CreatePasswordReset(ctx, database.CreatePasswordResetParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.baseURL + passwordResetPath + "?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: "Someone asked to reset the password for your Chirpy account.\n\n" +
+			"Choose a new password within 30 minutes using this link:\n\n" +
+			link + "\n\n" +
+			"If it wasn't you, you can ignore this email; your password hasn't changed.\n",
+	})
+}
+
+// forgotPassword always answers 202 and does its work in the background,
+// so neither the status nor the response time reveals whether an account
+// exists for the email.
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	go func() {
+		user, err := cfg.queries.GetUserByEmail(context.Background(), params.Email)
+		if err != nil {
+			return
+		}
+		if err := cfg.sendPasswordReset(context.Background(), user); err != nil {
+			log.Printf("couldn't send password reset: %s", err)
+		}
+	}()
+	w.WriteHeader(202)
+}
+
+// errInvalidResetLink covers every way a reset link can be no good, so
+// the answer doesn't say which.
+var errInvalidResetLink = errors.New("Invalid or expired reset link")
+
+// applyPasswordReset sets a new password from a reset link and logs the
+// user out everywhere: access tokens, refresh tokens, OAuth apps' tokens
+// and personal API keys all stop working, so nothing an attacker set up
+// survives the recovery. Consuming the link and every change commit
+// together, so a failure part way leaves the link usable and nothing
+// changed. The password must already have passed the policy.
+func (cfg *apiConfig) applyPasswordReset(ctx context.Context, token, password string) error {
+	userID, err := auth.ValidateSignedToken(token, auth.TokenTypePasswordReset, cfg.secretPhrase)
+	if err != nil {
+		return errInvalidResetLink
+	}
+	reset, err := cfg.queries.GetPasswordReset(ctx, auth.HashToken(token))
+	if err != nil || reset.UserID != userID {
+		return errInvalidResetLink
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("couldn't hash password: %w", err)
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	n, err := qtx.UsePasswordReset(ctx, reset.TokenHash)
+	if err != nil {
+		return fmt.Errorf("couldn't use password reset: %w", err)
+	}
+	if n == 0 {
+		return errInvalidResetLink
+	}
+	err = qtx.UpdatePassword(ctx, database.UpdatePasswordParams{
+		ID:             userID,
+		HashedPassword: hash,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't update password: %w", err)
+	}
+	err = qtx.RevokeUserTokens(ctx, database.RevokeUserTokensParams{
+		ValidAfter: time.Now().UTC(),
+		ID:         userID,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't revoke access tokens: %w", err)
+	}
+	if err := qtx.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return fmt.Errorf("couldn't revoke refresh tokens: %w", err)
+	}
+	if err := qtx.RevokeUserOAuthTokens(ctx, userID); err != nil {
+		return fmt.Errorf("couldn't revoke oauth tokens: %w", err)
+	}
+	if err := qtx.RevokeUserAPIKeys(ctx, userID); err != nil {
+		return fmt.Errorf("couldn't revoke api keys: %w", err)
+	}
+	// other links sent before this one are no longer needed
+	if err := qtx.ExpirePasswordResets(ctx, userID); err != nil {
+		return fmt.Errorf("couldn't expire password resets: %w", err)
+	}
+	return tx.Commit()
+}
+
+// resetPassword is applyPasswordReset for API clients.
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if !cfg.checkPassword(w, params.Password) {
+		return
+	}
+
+	err = cfg.applyPasswordReset(context.Background(), params.Token, params.Password)
+	if errors.Is(err, errInvalidResetLink) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't reset password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reset your password - Chirpy</title>
+</head>
+<body>
+<h1>Reset your Chirpy password</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Done}}
+<p>Your password has been changed and you've been signed out everywhere. Sign in again with your new password.</p>
+{{else if .Token}}
+<form method="post" action="` + passwordResetPath + `">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>New password <input type="password" name="password" autocomplete="new-password" required></label></p>
+<p><button type="submit">Change password</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// renderResetPassword shows the reset form for token, or only msg when
+// there's no token to post.
+func renderResetPassword(w http.ResponseWriter, code int, token, msg string, done bool) {
+	data := struct {
+		Token string
+		Error string
+		Done  bool
+	}{Token: token, Error: msg, Done: done}
+
+	// the page takes a password and carries the link's token, so nobody
+	// may frame it, cache it or see it in a Referer
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(code)
+	if err := resetPasswordPage.Execute(w, data); err != nil {
+		log.Printf("couldn't render reset password page: %s", err)
+	}
+}
+
+// resetPasswordForm is the page the reset email links to.
+func (cfg *apiConfig) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		renderResetPassword(w, 400, "", errInvalidResetLink.Error(), false)
+		return
+	}
+	renderResetPassword(w, 200, token, "", false)
+}
+
+// submitResetPasswordForm is resetPassword for the form.
+func (cfg *apiConfig) submitResetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderResetPassword(w, 400, "", "Invalid form", false)
+		return
+	}
+	token := r.PostForm.Get("token")
+	password := r.PostForm.Get("password")
+	if problems := cfg.passwordPolicy.Check(password); len(problems) > 0 {
+		renderResetPassword(w, 400, token, problems[0].Message, false)
+		return
+	}
+
+	err := cfg.applyPasswordReset(context.Background(), token, password)
+	if errors.Is(err, errInvalidResetLink) {
+		renderResetPassword(w, 400, "", err.Error(), false)
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't reset password: %s", err)
+		renderResetPassword(w, 500, token, "Something went wrong, please try again", false)
+		return
+	}
+	renderResetPassword(w, 200, "", "", true)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+// validationError is the body of a 400 for input that's well formed but
+// unacceptable, with each field's problems so clients can show them inline.
+type validationError struct {
+	Error  string                            `json:"error"`
+	Fields map[string][]auth.PasswordProblem `json:"fields"`
+}
+
+// setPasswordParamsFromEnv overrides the Argon2id defaults with any of
+// ARGON2_MEMORY (in KiB), ARGON2_ITERATIONS, ARGON2_PARALLELISM and
+// ARGON2_SALT_LENGTH. go run ./cmd/argon2-bench suggests values.
+func setPasswordParamsFromEnv() error {
+	params := auth.PasswordParams()
+	for _, v := range []struct {
+		name string
+		set  func(uint32)
+		max  uint64
+	}{
+		{"ARGON2_MEMORY", func(n uint32) { params.Memory = n }, 1<<32 - 1},
+		{"ARGON2_ITERATIONS", func(n uint32) { params.Iterations = n }, 1<<32 - 1},
+		{"ARGON2_PARALLELISM", func(n uint32) { params.Parallelism = uint8(n) }, 255},
+		{"ARGON2_SALT_LENGTH", func(n uint32) { params.SaltLength = n }, 1024},
+	} {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n > v.max {
+			return fmt.Errorf("invalid %s %q", v.name, s)
+		}
+		v.set(uint32(n))
+	}
+	return auth.SetPasswordParams(params)
+}
+
+// rehashPassword replaces user's hash, made with weaker parameters, now
+// that a login has supplied the password. A password changed in the
+// meantime is left alone.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("couldn't rehash password: %s", err)
+		return
+	}
+	_, err = cfg.queries.RehashPassword(ctx, database.RehashPasswordParams{
+		NewHash: hash,
+		ID:      user.ID,
+		OldHash: user.HashedPassword,
+	})
+	if err != nil {
+		log.Printf("couldn't rehash password: %s", err)
+	}
+}
+
+// passwordPolicyFromEnv starts from the default policy. PASSWORD_MIN_LENGTH
+// changes the minimum, PASSWORD_BANNED_FILE adds to the common passwords
+// refused, and BREACHED_PASSWORDS_PATH loads a Pwned Passwords file, or a
+// directory of its range files, to refuse breached passwords offline.
+func passwordPolicyFromEnv() (auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy()
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", v)
+		}
+		policy.MinLength = n
+	}
+	if path := os.Getenv("PASSWORD_BANNED_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return policy, err
+		}
+		defer f.Close()
+		banned, err := auth.ReadPasswordList(f)
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", path, err)
+		}
+		for p := range banned {
+			policy.Banned[p] = true
+		}
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_PATH"); path != "" {
+		breached, err := auth.LoadBreachedPasswords(path)
+		if err != nil {
+			return policy, err
+		}
+		log.Printf("loaded %d breached password hashes", breached.Len())
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// checkPassword answers with the policy's problems and returns false if
+// password isn't acceptable as a new password.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, password string) bool {
+	problems := cfg.passwordPolicy.Check(password)
+	if len(problems) == 0 {
+		return true
+	}
+	respondWithBody(w, 400, validationError{
+		Error:  problems[0].Message,
+		Fields: map[string][]auth.PasswordProblem{"password": problems},
+	})
+	return false
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+// Profile is the public view of a user. Unlike User it never carries the
+// email address or any tokens, so it is safe to return to anyone.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	Avatar      string    `json:"avatar"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	IsPrivate   bool      `json:"is_private"`
+}
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+
+	defaultSearchResults = 10
+	maxSearchResults     = 50
+	// shorter queries match too many users to be worth ranking
+	minSearchLength = 2
+	// trigrams need three characters to say anything
+	minFuzzySearchLength = 3
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedHandles can't be claimed because they would be confused with
+// staff accounts or collide with routes under /api/users.
+var reservedHandles = map[string]bool{
+	"admin":           true,
+	"administrator":   true,
+	"api":             true,
+	"app":             true,
+	"chirpy":          true,
+	"help":            true,
+	"me":              true,
+	"moderator":       true,
+	"recommendations": true,
+	"root":            true,
+	"search":          true,
+	"support":         true,
+	"system":          true,
+	"verify":          true,
+}
+
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("Handle must be 3-30 letters, digits or underscores")
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return errors.New("Handle is reserved")
+	}
+	return nil
+}
+
+// defaultHandle is used when a user registers without picking a handle.
+func defaultHandle() string {
+	key := make([]byte, 6)
+	rand.Read(key)
+	return "user_" + hex.EncodeToString(key)
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func profileFromUser(u database.User) Profile {
+	return Profile{
+		ID:          u.ID,
+		CreatedAt:   u.CreatedAt,
+		Handle:      u.Handle,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		Avatar:      u.Avatar,
+		IsChirpyRed: u.IsChirpyRed,
+		IsPrivate:   u.IsPrivate,
+	}
+}
+
+// likeEscaper makes user input safe to use as a LIKE prefix.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// lookupUser resolves a path value that is either a user id or a handle.
+func (cfg *apiConfig) lookupUser(ctx context.Context, idOrHandle string) (database.User, error) {
+	if id, err := uuid.Parse(idOrHandle); err == nil {
+		return cfg.queries.GetUserByID(ctx, id)
+	}
+	return cfg.queries.GetUserByHandle(ctx, strings.TrimPrefix(idOrHandle, "@"))
+}
+
+func (cfg *apiConfig) getProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.lookupUser(context.Background(), r.PathValue("idOrHandle"))
+	if err != nil {
+		log.Printf("couldn't find user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	// blocked users can't see each other's profiles either
+	viewer := cfg.viewerID(r)
+	if viewer != uuid.Nil {
+		blocked, err := cfg.queries.IsBlockedEitherWay(context.Background(), database.IsBlockedEitherWayParams{
+			UserA: viewer,
+			UserB: user.ID,
+		})
+		if err != nil {
+			log.Printf("couldn't check blocks: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if blocked {
+			w.WriteHeader(404)
+			return
+		}
+	}
+
+	respondWithBody(w, 200, profileFromUser(user))
+}
+
+func (cfg *apiConfig) updateProfile(w http.ResponseWriter, r *http.Request) {
+	tokenid, ok := cfg.authorizeRequest(w, r, auth.ScopeProfile)
+	if !ok {
+		return
+	}
+
+	// every field is optional; missing fields keep their current value
+	type parameters struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		Avatar      *string `json:"avatar"`
+		IsPrivate   *bool   `json:"is_private"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), tokenid)
+	if err != nil {
+		log.Printf("couldn't find user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	update := database.UpdateProfileParams{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Avatar:      user.Avatar,
+		IsPrivate:   user.IsPrivate,
+	}
+
+	if params.Handle != nil {
+		if err := validateHandle(*params.Handle); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		update.Handle = *params.Handle
+	}
+	if params.DisplayName != nil {
+		if utf8.RuneCountInString(*params.DisplayName) > maxDisplayNameLength {
+			respondWithError(w, 400, "Display name is too long")
+			return
+		}
+		update.DisplayName = strings.TrimSpace(*params.DisplayName)
+	}
+	if params.Bio != nil {
+		if utf8.RuneCountInString(*params.Bio) > maxBioLength {
+			respondWithError(w, 400, "Bio is too long")
+			return
+		}
+		update.Bio = *params.Bio
+	}
+	if params.Avatar != nil {
+		if *params.Avatar != "" {
+			u, err := url.Parse(*params.Avatar)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				respondWithError(w, 400, "Avatar must be an http or https URL")
+				return
+			}
+		}
+		update.Avatar = *params.Avatar
+	}
+	if params.IsPrivate != nil {
+		update.IsPrivate = *params.IsPrivate
+	}
+	wentPublic := user.IsPrivate && !update.IsPrivate
+
+	user, err = cfg.queries.UpdateProfile(context.Background(), update)
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, 409, "Handle is already taken")
+			return
+		}
+		log.Printf("couldn't update profile: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// nobody needs approval to follow a public account
+	if wentPublic {
+		err = cfg.queries.AcceptAllFollowRequests(context.Background(), user.ID)
+		if err != nil {
+			log.Printf("couldn't accept pending follow requests: %s", err)
+		}
+	}
+
+	respondWithBody(w, 200, profileFromUser(user))
+}
+
+// searchUsers backs mention autocomplete, so it only does indexed work:
+// a prefix match and, for longer queries, a trigram similarity match over
+// handle and display name, ranked with precomputed follower counts.
+func (cfg *apiConfig) searchUsers(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@"))
+	if q == "" {
+		respondWithError(w, 400, "Missing search query")
+		return
+	}
+
+	limit := defaultSearchResults
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = min(n, maxSearchResults)
+	}
+
+	length := utf8.RuneCountInString(q)
+	if length < minSearchLength {
+		respondWithBody(w, 200, []Profile{})
+		return
+	}
+
+	users, err := cfg.queries.SearchUsers(context.Background(), database.SearchUsersParams{
+		Prefix:     likeEscaper.Replace(q) + "%",
+		Fuzzy:      length >= minFuzzySearchLength,
+		Query:      q,
+		ViewerID:   cfg.viewerID(r),
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		log.Printf("couldn't search users: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	profiles := []Profile{}
+	for _, u := range users {
+		profiles = append(profiles, profileFromUser(u))
+	}
+	respondWithBody(w, 200, profiles)
+}
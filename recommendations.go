@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+type Recommendation struct {
+	Profile
+	Reason string  `json:"reason"`
+	Score  float64 `json:"score"`
+}
+
+const (
+	recommendationsPerUser       = 50
+	defaultRecommendations       = 10
+	defaultRecommendationsPeriod = time.Hour
+)
+
+func (cfg *apiConfig) getRecommendations(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	limit := defaultRecommendations
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = min(n, recommendationsPerUser)
+	}
+
+	// the stored rows can be up to one refresh period old, so the query
+	// filters out anyone followed, blocked or muted since then
+	rows, err := cfg.queries.ListRecommendations(context.Background(), database.ListRecommendationsParams{
+		UserID: caller,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		log.Printf("couldn't list recommendations: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	recommendations := []Recommendation{}
+	for _, row := range rows {
+		recommendations = append(recommendations, Recommendation{
+			Profile: Profile{
+				ID:          row.ID,
+				CreatedAt:   row.CreatedAt,
+				Handle:      row.Handle,
+				DisplayName: row.DisplayName,
+				Bio:         row.Bio,
+				Avatar:      row.Avatar,
+				IsChirpyRed: row.IsChirpyRed,
+				IsPrivate:   row.IsPrivate,
+			},
+			Reason: row.Reason,
+			Score:  row.Score,
+		})
+	}
+	respondWithBody(w, 200, recommendations)
+}
+
+// refreshRecommendations rebuilds the recommendations table in a single
+// transaction so readers never see it half empty. Every instance runs the
+// loop, so it takes an advisory lock held until the transaction ends and
+// reports false without refreshing when another instance already holds it.
+func (cfg *apiConfig) refreshRecommendations(ctx context.Context) (bool, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.queries.WithTx(tx)
+	locked, err := qtx.TryLockRecommendations(ctx)
+	if err != nil || !locked {
+		return false, err
+	}
+	if err := qtx.DeleteRecommendations(ctx); err != nil {
+		return false, err
+	}
+	if err := qtx.ComputeRecommendations(ctx, recommendationsPerUser); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// recommendationsLoop refreshes recommendations now and then once per period.
+func (cfg *apiConfig) recommendationsLoop(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		refreshed, err := cfg.refreshRecommendations(context.Background())
+		if err != nil {
+			log.Printf("couldn't refresh recommendations: %s", err)
+		} else if !refreshed {
+			log.Printf("another instance is refreshing recommendations; skipping")
+		} else {
+			log.Printf("refreshed recommendations in %s", time.Since(start))
+		}
+		<-ticker.C
+	}
+}
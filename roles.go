@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+type adminContextKey struct{}
+
+// Role is a role granted to a user. GrantedBy is null for roles granted
+// with the admin key.
+type Role struct {
+	Role      string     `json:"role"`
+	GrantedAt time.Time  `json:"granted_at"`
+	GrantedBy *uuid.UUID `json:"granted_by"`
+}
+
+func roleFromDB(r database.UserRole) Role {
+	role := Role{Role: r.Role, GrantedAt: r.GrantedAt}
+	if r.GrantedBy.Valid {
+		role.GrantedBy = &r.GrantedBy.UUID
+	}
+	return role
+}
+
+// requirePermission guards an admin endpoint. The caller is either a user
+// whose roles grant permission, signed in with a first-party access token,
+// or whoever holds ADMIN_API_KEY, sent as "Authorization: ApiKey <key>",
+// who may do anything and is how the first admin gets their role.
+func (cfg *apiConfig) requirePermission(permission string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, err := auth.GetAPIKey(r.Header); err == nil {
+			if cfg.adminkey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(cfg.adminkey)) != 1 {
+				log.Printf("admin key does not match")
+				w.WriteHeader(401)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := cfg.authenticate(r)
+		if err != nil {
+			log.Printf("token is invalid: %s", err)
+			w.WriteHeader(401)
+			return
+		}
+		// looked up every time, so a revoked role stops working at once
+		rows, err := cfg.queries.ListUserRoles(r.Context(), userID)
+		if err != nil {
+			log.Printf("couldn't list roles: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		roles := []string{auth.RoleUser}
+		for _, row := range rows {
+			roles = append(roles, row.Role)
+		}
+		if !auth.RolesAllow(roles, permission) {
+			respondWithError(w, 403, "You don't have permission to do that")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, userID)))
+	})
+}
+
+// adminActor is the user requirePermission let through, or null for the
+// admin key.
+func adminActor(r *http.Request) uuid.NullUUID {
+	id, ok := r.Context().Value(adminContextKey{}).(uuid.UUID)
+	return uuid.NullUUID{UUID: id, Valid: ok}
+}
+
+func actorDetail(r *http.Request) string {
+	if actor := adminActor(r); actor.Valid {
+		return "by " + actor.UUID.String()
+	}
+	return "by admin key"
+}
+
+// adminRoleUser parses {userID} and {role} and checks both exist.
+func (cfg *apiConfig) adminRoleUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, string, bool) {
+	userID, ok := cfg.adminUser(w, r)
+	if !ok {
+		return uuid.Nil, "", false
+	}
+	role := r.PathValue("role")
+	if !auth.GrantableRole(role) {
+		respondWithError(w, 400, "Unknown role "+role)
+		return uuid.Nil, "", false
+	}
+	_, err := cfg.queries.GetUserByID(context.Background(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "No user with that id")
+		return uuid.Nil, "", false
+	}
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return uuid.Nil, "", false
+	}
+	return userID, role, true
+}
+
+func (cfg *apiConfig) adminListRoles(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.adminUser(w, r)
+	if !ok {
+		return
+	}
+	rows, err := cfg.queries.ListUserRoles(context.Background(), userID)
+	if err != nil {
+		log.Printf("couldn't list roles: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	roles := []Role{}
+	for _, row := range rows {
+		roles = append(roles, roleFromDB(row))
+	}
+	respondWithBody(w, 200, roles)
+}
+
+// adminGrantRole gives a user a role. Granting one they already have
+// changes nothing.
+func (cfg *apiConfig) adminGrantRole(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := cfg.adminRoleUser(w, r)
+	if !ok {
+		return
+	}
+	n, err := cfg.queries.GrantUserRole(context.Background(), database.GrantUserRoleParams{
+		UserID:    userID,
+		Role:      role,
+		GrantedBy: adminActor(r),
+	})
+	if err != nil {
+		log.Printf("couldn't grant role: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n > 0 {
+		cfg.recordSecurityEvent(context.Background(), r, userID, SecurityRoleGranted, role+" "+actorDetail(r))
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) adminRevokeRole(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := cfg.adminRoleUser(w, r)
+	if !ok {
+		return
+	}
+	n, err := cfg.queries.RevokeUserRole(context.Background(), database.RevokeUserRoleParams{
+		UserID: userID,
+		Role:   role,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke role: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "User doesn't have that role")
+		return
+	}
+	cfg.recordSecurityEvent(context.Background(), r, userID, SecurityRoleRevoked, role+" "+actorDetail(r))
+	w.WriteHeader(204)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+type SecurityEventKind string
+
+const (
+	SecurityRefreshTokenReuse      SecurityEventKind = "refresh_token_reuse"
+	SecuritySessionsRevokedByAdmin SecurityEventKind = "sessions_revoked_by_admin"
+	SecurityOAuthCodeReuse         SecurityEventKind = "oauth_code_reuse"
+	SecurityOAuthTokenReuse        SecurityEventKind = "oauth_token_reuse"
+	SecurityLoginLockout           SecurityEventKind = "login_lockout"
+	SecurityLoginUnlockedByAdmin   SecurityEventKind = "login_unlocked_by_admin"
+	SecurityRoleGranted            SecurityEventKind = "role_granted"
+	SecurityRoleRevoked            SecurityEventKind = "role_revoked"
+)
+
+// clientIP is the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordSecurityEvent keeps an audit trail of suspicious activity on an
+// account. Failures are logged, never returned.
+func (cfg *apiConfig) recordSecurityEvent(ctx context.Context, r *http.Request, userID uuid.UUID, kind SecurityEventKind, detail string) {
+	log.Printf("security event %s for user %s: %s", kind, userID, detail)
+	err := cfg.queries.CreateSecurityEvent(ctx, database.CreateSecurityEventParams{
+		UserID:    userID,
+		Kind:      string(kind),
+		Ip:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Printf("couldn't record %s security event: %s", kind, err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+var errTokenRevoked = errors.New("access token has been revoked")
+
+// Session is one login on one device. Its ID is the refresh token family,
+// which stays the same as the refresh token rotates.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+}
+
+// authenticateSession is authenticate for endpoints that need to know which
+// session the access token belongs to. The session is uuid.Nil for tokens
+// issued before sessions were tracked.
+func (cfg *apiConfig) authenticateSession(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	claims, err := cfg.accessClaims(r)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	return claims.UserID, claims.SessionID, nil
+}
+
+// checkAccessToken refuses a valid access token that has since been
+// revoked: one issued before the user's tokens_valid_after, or whose
+// session or app token family has ended. That way logging out or changing
+// the password takes effect at once, not when the token expires.
+func (cfg *apiConfig) checkAccessToken(ctx context.Context, claims *auth.AccessClaims) error {
+	validAfter, err := cfg.queries.GetTokensValidAfter(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	// iat only has second precision
+	if validAfter.Valid && claims.IssuedAt.Before(validAfter.Time.Truncate(time.Second)) {
+		return errTokenRevoked
+	}
+
+	active := true
+	switch {
+	case claims.ClientID != uuid.Nil:
+		active, err = cfg.queries.OAuthFamilyActive(ctx, claims.SessionID)
+	case claims.SessionID != uuid.Nil:
+		active, err = cfg.queries.SessionActive(ctx, claims.SessionID)
+	}
+	if err != nil {
+		return err
+	}
+	if !active {
+		return errTokenRevoked
+	}
+	return nil
+}
+
+// userTier is the tier claim for user's access tokens.
+func userTier(user database.User) string {
+	if user.IsChirpyRed {
+		return auth.TierRed
+	}
+	return auth.TierFree
+}
+
+func (cfg *apiConfig) sessionsFor(ctx context.Context, userID, current uuid.UUID) ([]Session, error) {
+	tokens, err := cfg.queries.ListActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, t := range tokens {
+		sessions = append(sessions, Session{
+			ID:         t.FamilyID,
+			CreatedAt:  t.SessionStartedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt:  t.ExpiresAt,
+			UserAgent:  t.UserAgent,
+			IP:         t.Ip,
+			Current:    t.FamilyID == current,
+		})
+	}
+	return sessions, nil
+}
+
+func (cfg *apiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+	caller, current, err := cfg.authenticateSession(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	sessions, err := cfg.sessionsFor(context.Background(), caller, current)
+	if err != nil {
+		log.Printf("couldn't list sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	respondWithBody(w, 200, sessions)
+}
+
+// revokeSession logs one of the caller's devices out, access token and
+// all.
+func (cfg *apiConfig) revokeSession(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	cfg.revokeUserSession(w, r, caller)
+}
+
+// revokeAllSessions logs out everywhere except the session making the
+// request.
+func (cfg *apiConfig) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	caller, current, err := cfg.authenticateSession(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	cfg.revokeUserSessions(w, caller, current)
+}
+
+func (cfg *apiConfig) revokeUserSession(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid session id")
+		return
+	}
+
+	n, err := cfg.queries.RevokeSession(context.Background(), database.RevokeSessionParams{
+		UserID:   userID,
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke session: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "No active session with that id")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// revokeUserSessions revokes every session of userID but keep, which may be
+// uuid.Nil to revoke them all.
+func (cfg *apiConfig) revokeUserSessions(w http.ResponseWriter, userID, keep uuid.UUID) {
+	n, err := cfg.queries.RevokeOtherSessions(context.Background(), database.RevokeOtherSessionsParams{
+		UserID:   userID,
+		FamilyID: keep,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		Revoked int64 `json:"revoked"`
+	}
+	respondWithBody(w, 200, response{Revoked: n})
+}
+
+// adminUser parses the {userID} of an admin user endpoint. The caller's
+// permission was checked by requirePermission.
+func (cfg *apiConfig) adminUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func (cfg *apiConfig) adminListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := cfg.sessionsFor(context.Background(), userID, uuid.Nil)
+	if err != nil {
+		log.Printf("couldn't list sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	respondWithBody(w, 200, sessions)
+}
+
+func (cfg *apiConfig) adminRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.adminUser(w, r)
+	if !ok {
+		return
+	}
+	cfg.recordSecurityEvent(context.Background(), r, userID, SecuritySessionsRevokedByAdmin, "session "+r.PathValue("sessionID"))
+	cfg.revokeUserSession(w, r, userID)
+}
+
+func (cfg *apiConfig) adminRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.adminUser(w, r)
+	if !ok {
+		return
+	}
+	cfg.recordSecurityEvent(context.Background(), r, userID, SecuritySessionsRevokedByAdmin, "all sessions")
+	// app tokens too, for an account that may be compromised
+	err := cfg.queries.RevokeUserTokens(context.Background(), database.RevokeUserTokensParams{
+		ValidAfter: time.Now().UTC(),
+		ID:         userID,
+	})
+	if err != nil {
+		log.Printf("couldn't revoke access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	cfg.revokeUserSessions(w, userID, uuid.Nil)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+const (
+	signingKeysChannel = "signing_keys"
+
+	// a retired key keeps verifying tokens for the longest access token
+	// lifetime, so rotating doesn't log anyone out
+	signingKeyOverlap = time.Hour
+)
+
+// SigningKey describes a JWT signing key without its private half.
+type SigningKey struct {
+	ID        string     `json:"kid"`
+	Algorithm string     `json:"alg"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at"`
+}
+
+// loadSigningKeys loads the current and recently retired signing keys from
+// the database into cfg.jwtKeys. Every server does this at startup and
+// whenever a rotation is announced on signingKeysChannel.
+func (cfg *apiConfig) loadSigningKeys(ctx context.Context) ([]database.SigningKey, error) {
+	rows, err := cfg.queries.ListSigningKeys(ctx, time.Now().Add(-signingKeyOverlap))
+	if err != nil {
+		return nil, err
+	}
+	keys := []auth.SigningKey{}
+	for _, row := range rows {
+		private := row.PrivateKey
+		if auth.IsSealedPrivateKey(private) {
+			private, err = auth.OpenPrivateKey(cfg.signingKeyKEK, row.Kid, private)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't open signing key %s: %w", row.Kid, err)
+			}
+		} else {
+			log.Printf("signing key %s is stored unencrypted; rotate it with revoke_previous", row.Kid)
+		}
+		key, err := auth.ParseSigningKey(row.Kid, row.Algorithm, private)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse signing key %s: %w", row.Kid, err)
+		}
+		if row.RetiredAt.Valid {
+			key.NotAfter = row.RetiredAt.Time.Add(signingKeyOverlap)
+		}
+		if row.ReplacesSecret {
+			key.SecretUntil = row.CreatedAt.Add(signingKeyOverlap)
+		}
+		keys = append(keys, key)
+	}
+	cfg.jwtKeys.SetKeys(keys)
+	return rows, nil
+}
+
+// reloadSigningKeys is loadSigningKeys for the event listener; a failed
+// reload keeps the keys we have.
+func (cfg *apiConfig) reloadSigningKeys() {
+	if _, err := cfg.loadSigningKeys(context.Background()); err != nil {
+		log.Printf("couldn't reload signing keys: %s", err)
+	}
+}
+
+// setupSigningKeys loads the signing keys and, when alg asks for
+// asymmetric signing and there's no key yet, makes the first one. Without
+// keys access tokens stay HS256 with SECRET.
+func (cfg *apiConfig) setupSigningKeys(ctx context.Context, alg string) error {
+	rows, err := cfg.loadSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 || alg == "" || alg == auth.AlgHS256 {
+		return nil
+	}
+	_, err = cfg.rotateSigningKey(ctx, alg, false)
+	return err
+}
+
+// rotateSigningKey makes a new signing key and retires the others. With
+// revokePrevious the old keys stop verifying tokens at once, for when a
+// key has leaked; otherwise they keep working for signingKeyOverlap. The
+// private key is stored encrypted under JWT_KEY_ENCRYPTION_KEY, so the
+// database alone can't forge tokens.
+func (cfg *apiConfig) rotateSigningKey(ctx context.Context, alg string, revokePrevious bool) ([]database.SigningKey, error) {
+	if cfg.signingKeyKEK == nil {
+		return nil, errors.New("JWT_KEY_ENCRYPTION_KEY must be set to store signing keys")
+	}
+	key, err := auth.GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	pemKey, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	private, err := auth.SealPrivateKey(cfg.signingKeyKEK, key.ID, pemKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.queries.WithTx(tx)
+
+	previous, err := qtx.ListSigningKeys(ctx, now.Add(-signingKeyOverlap))
+	if err != nil {
+		return nil, err
+	}
+	for kid, retiredAt := range signingKeyRetirements(previous, now, revokePrevious) {
+		err = qtx.RetireSigningKey(ctx, database.RetireSigningKeyParams{
+			RetiredAt: retiredAt,
+			Kid:       kid,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = qtx.CreateSigningKey(ctx, database.CreateSigningKeyParams{
+		Kid:        key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: private,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = qtx.DeleteSigningKeysRetiredBefore(ctx, sql.NullTime{Time: time.Now().Add(-signingKeyOverlap), Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	// delivered on commit, so the other servers see the new key
+	if err := qtx.NotifySigningKeys(ctx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cfg.loadSigningKeys(ctx)
+}
+
+// signingKeyRetirements is the new retired_at of each key a rotation at
+// now changes. Keys still current retire now. With revokePrevious every
+// key is backdated past its overlap, including ones an earlier rotation
+// retired that would otherwise keep verifying for the rest of theirs.
+func signingKeyRetirements(keys []database.SigningKey, now time.Time, revokePrevious bool) map[string]time.Time {
+	retiredAt := now
+	if revokePrevious {
+		retiredAt = now.Add(-signingKeyOverlap)
+	}
+	changes := map[string]time.Time{}
+	for _, key := range keys {
+		if !key.RetiredAt.Valid || key.RetiredAt.Time.After(retiredAt) {
+			changes[key.Kid] = retiredAt
+		}
+	}
+	return changes
+}
+
+func signingKeysFromDB(rows []database.SigningKey) []SigningKey {
+	keys := []SigningKey{}
+	for _, row := range rows {
+		key := SigningKey{
+			ID:        row.Kid,
+			Algorithm: row.Algorithm,
+			CreatedAt: row.CreatedAt,
+		}
+		if row.RetiredAt.Valid {
+			key.RetiredAt = &row.RetiredAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// jwks publishes the public keys access tokens are signed with, so other
+// services can verify them without the secret. Verifiers should refetch it
+// when a token names a kid they haven't seen, as happens after a rotation.
+func (cfg *apiConfig) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithBody(w, 200, cfg.jwtKeys.JWKS())
+}
+
+func (cfg *apiConfig) adminListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	rows, err := cfg.queries.ListSigningKeys(context.Background(), time.Now().Add(-signingKeyOverlap))
+	if err != nil {
+		log.Printf("couldn't list signing keys: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	respondWithBody(w, 200, signingKeysFromDB(rows))
+}
+
+func (cfg *apiConfig) adminRotateSigningKeys(w http.ResponseWriter, r *http.Request) {
+
+	type parameters struct {
+		Algorithm      string `json:"algorithm"`
+		RevokePrevious bool   `json:"revoke_previous"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	if params.Algorithm == "" {
+		// keep the current algorithm, moving off HS256 by default
+		params.Algorithm = cfg.jwtKeys.Algorithm()
+		if params.Algorithm == auth.AlgHS256 {
+			params.Algorithm = auth.AlgEdDSA
+		}
+	}
+	if params.Algorithm != auth.AlgEdDSA && params.Algorithm != auth.AlgRS256 {
+		respondWithError(w, 400, "algorithm must be EdDSA or RS256")
+		return
+	}
+
+	rows, err := cfg.rotateSigningKey(context.Background(), params.Algorithm, params.RevokePrevious)
+	if err != nil {
+		log.Printf("couldn't rotate signing keys: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	log.Printf("rotated JWT signing key to %s (%s)", rows[0].Kid, rows[0].Algorithm)
+	respondWithBody(w, 201, signingKeysFromDB(rows))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+// rotate applies a rotation at now to keys the way rotateSigningKey does
+// and adds the new key.
+func rotate(keys []database.SigningKey, kid string, now time.Time, revokePrevious bool) []database.SigningKey {
+	changes := signingKeyRetirements(keys, now, revokePrevious)
+	for i, key := range keys {
+		if at, ok := changes[key.Kid]; ok {
+			keys[i].RetiredAt = sql.NullTime{Time: at, Valid: true}
+		}
+	}
+	return append(keys, database.SigningKey{Kid: kid, CreatedAt: now})
+}
+
+func TestSigningKeyRetirements(t *testing.T) {
+	start := time.Now()
+	keys := []database.SigningKey{{Kid: "first", CreatedAt: start}}
+
+	keys = rotate(keys, "second", start.Add(time.Minute), false)
+	if !keys[0].RetiredAt.Valid || keys[1].RetiredAt.Valid {
+		t.Fatalf("expected only the first key retired but got %+v", keys)
+	}
+	firstRetired := keys[0].RetiredAt.Time
+
+	// an ordinary rotation doesn't extend a key that's already retired
+	keys = rotate(keys, "third", start.Add(2*time.Minute), false)
+	if !keys[0].RetiredAt.Time.Equal(firstRetired) {
+		t.Errorf("expected the first key to stay retired at %s but got %s", firstRetired, keys[0].RetiredAt.Time)
+	}
+
+	// revoking cuts every earlier key's overlap short, not just the current one
+	now := start.Add(3 * time.Minute)
+	keys = rotate(keys, "fourth", now, true)
+	for _, key := range keys[:3] {
+		if !key.RetiredAt.Valid || key.RetiredAt.Time.Add(signingKeyOverlap).After(now) {
+			t.Errorf("expected %s to stop verifying at once but got %+v", key.Kid, key.RetiredAt)
+		}
+	}
+	if keys[3].RetiredAt.Valid {
+		t.Errorf("expected the new key to stay current")
+	}
+}
@@ -0,0 +1,22 @@
+-- name: CreateChirpEvent :one
+INSERT INTO chirp_events (created_at, type, chirp_id, user_id, body, author_private)
+VALUES (
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    (SELECT is_private FROM users WHERE id = $3)
+)
+RETURNING *;
+
+-- name: NotifyChirpEvent :exec
+SELECT pg_notify('chirp_events', sqlc.arg(payload)::text);
+
+-- name: ListChirpEventsAfter :many
+SELECT * FROM chirp_events WHERE id > $1
+ORDER BY id
+LIMIT $2;
+
+-- name: DeleteChirpEventsBefore :exec
+DELETE FROM chirp_events WHERE created_at < $1;
@@ -10,17 +10,64 @@ VALUES (
 RETURNING *;
 
 -- name: ListChirps :many
-SELECT * FROM chirps
-ORDER BY created_at;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+-- hidden from the viewer: blocks either way, mutes, and private authors
+-- the viewer doesn't follow
+WHERE NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = sqlc.arg(viewer_id) AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.arg(viewer_id))
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = sqlc.arg(viewer_id) AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = sqlc.arg(viewer_id) OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = sqlc.arg(viewer_id) AND follows.followee_id = users.id
+  ))
+ORDER BY chirps.created_at;
 
 -- name: ListUserChirps :many
-SELECT * FROM chirps WHERE user_id = $1
-ORDER BY created_at;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = sqlc.arg(user_id)
+  -- hidden from the viewer: blocks either way, mutes, and private
+  -- authors the viewer doesn't follow
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = sqlc.arg(viewer_id) AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.arg(viewer_id))
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = sqlc.arg(viewer_id) AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = sqlc.arg(viewer_id) OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = sqlc.arg(viewer_id) AND follows.followee_id = users.id
+  ))
+ORDER BY chirps.created_at;
 
 
 -- name: ChirpByID :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: VisibleChirpByID :one
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = sqlc.arg(id)
+  -- hidden from the viewer: blocks either way, mutes, and private
+  -- authors the viewer doesn't follow
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = sqlc.arg(viewer_id) AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.arg(viewer_id))
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = sqlc.arg(viewer_id) AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = sqlc.arg(viewer_id) OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = sqlc.arg(viewer_id) AND follows.followee_id = users.id
+  ));
+
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps WHERE id = $1;
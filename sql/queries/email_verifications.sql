@@ -0,0 +1,20 @@
+-- name: CreateEmailVerification :exec
+INSERT INTO email_verifications (token_hash, created_at, user_id, email, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: GetEmailVerification :one
+SELECT * FROM email_verifications WHERE token_hash = $1;
+
+-- name: UseEmailVerification :execrows
+UPDATE email_verifications SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW();
+
+-- name: CountEmailVerificationsSince :one
+SELECT COUNT(*) FROM email_verifications
+WHERE user_id = $1 AND created_at > $2;
@@ -0,0 +1,67 @@
+-- name: CreateFollow :exec
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteFollow :exec
+DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: DeleteFollowsBetween :exec
+DELETE FROM follows
+WHERE (follower_id = sqlc.arg(user_a) AND followee_id = sqlc.arg(user_b))
+   OR (follower_id = sqlc.arg(user_b) AND followee_id = sqlc.arg(user_a));
+
+-- name: ListFolloweeIDs :many
+SELECT followee_id FROM follows WHERE follower_id = $1;
+
+-- name: IsFollowing :one
+SELECT EXISTS (
+    SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2
+);
+
+-- name: CreateFollowRequest :exec
+INSERT INTO follow_requests (requester_id, target_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteFollowRequest :execrows
+DELETE FROM follow_requests WHERE requester_id = $1 AND target_id = $2;
+
+-- name: DeleteFollowRequestsBetween :exec
+DELETE FROM follow_requests
+WHERE (requester_id = sqlc.arg(user_a) AND target_id = sqlc.arg(user_b))
+   OR (requester_id = sqlc.arg(user_b) AND target_id = sqlc.arg(user_a));
+
+-- name: ListFollowRequests :many
+SELECT users.* FROM follow_requests
+JOIN users ON users.id = follow_requests.requester_id
+WHERE follow_requests.target_id = $1
+ORDER BY follow_requests.created_at;
+
+-- name: AcceptFollowRequest :execrows
+WITH accepted AS (
+    DELETE FROM follow_requests
+    WHERE requester_id = $1 AND target_id = $2
+    RETURNING requester_id, target_id
+)
+INSERT INTO follows (follower_id, followee_id, created_at)
+SELECT requester_id, target_id, NOW() FROM accepted
+ON CONFLICT DO NOTHING;
+
+-- name: AcceptAllFollowRequests :exec
+WITH accepted AS (
+    DELETE FROM follow_requests
+    WHERE target_id = $1
+    RETURNING requester_id, target_id
+)
+INSERT INTO follows (follower_id, followee_id, created_at)
+SELECT requester_id, target_id, NOW() FROM accepted
+ON CONFLICT DO NOTHING;
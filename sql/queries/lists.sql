@@ -0,0 +1,88 @@
+-- name: CreateList :one
+INSERT INTO lists (id, created_at, updated_at, owner_id, name, description, is_private)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetList :one
+SELECT * FROM lists WHERE id = $1;
+
+-- name: ListOwnedLists :many
+SELECT * FROM lists WHERE owner_id = $1
+ORDER BY created_at;
+
+-- name: UpdateList :one
+UPDATE lists SET name = $2, description = $3, is_private = $4, updated_at = NOW() WHERE id = $1
+RETURNING *;
+
+-- name: DeleteList :exec
+DELETE FROM lists WHERE id = $1;
+
+-- name: AddListMember :exec
+INSERT INTO list_members (list_id, user_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveListMember :exec
+DELETE FROM list_members WHERE list_id = $1 AND user_id = $2;
+
+-- name: CountListMembers :one
+SELECT COUNT(*) FROM list_members WHERE list_id = $1;
+
+-- name: ListListMembers :many
+SELECT users.* FROM list_members
+JOIN users ON users.id = list_members.user_id
+WHERE list_members.list_id = $1
+ORDER BY list_members.created_at;
+
+-- name: ListTimelineChirps :many
+SELECT chirps.* FROM chirps
+JOIN list_members ON list_members.user_id = chirps.user_id
+JOIN users ON users.id = chirps.user_id
+WHERE list_members.list_id = sqlc.arg(list_id)
+  AND (chirps.created_at, chirps.id) < (sqlc.arg(before_time)::timestamp, sqlc.arg(before_id)::uuid)
+  -- hidden from the viewer: blocks either way, mutes, and private
+  -- authors the viewer doesn't follow
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = sqlc.arg(viewer_id) AND blocks.blocked_id = chirps.user_id)
+         OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.arg(viewer_id))
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM mutes WHERE mutes.muter_id = sqlc.arg(viewer_id) AND mutes.muted_id = chirps.user_id
+  )
+  AND (NOT users.is_private OR users.id = sqlc.arg(viewer_id) OR EXISTS (
+      SELECT 1 FROM follows WHERE follows.follower_id = sqlc.arg(viewer_id) AND follows.followee_id = users.id
+  ))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: SubscribeList :exec
+INSERT INTO list_subscriptions (list_id, user_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnsubscribeList :exec
+DELETE FROM list_subscriptions WHERE list_id = $1 AND user_id = $2;
+
+-- name: ListSubscribedLists :many
+SELECT lists.* FROM list_subscriptions
+JOIN lists ON lists.id = list_subscriptions.list_id
+WHERE list_subscriptions.user_id = $1
+  AND (NOT lists.is_private OR lists.owner_id = $1)
+ORDER BY list_subscriptions.created_at;
@@ -0,0 +1,23 @@
+-- name: GetLoginThrottles :many
+SELECT * FROM login_throttles WHERE throttle_key = ANY(sqlc.arg(keys)::text[]);
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (throttle_key, failures, last_failure_at)
+VALUES (sqlc.arg(throttle_key), 1, sqlc.arg(failed_at))
+ON CONFLICT (throttle_key) DO UPDATE SET
+    failures = CASE WHEN login_throttles.last_failure_at < sqlc.arg(window_start)::timestamp
+        THEN 1 ELSE login_throttles.failures + 1 END,
+    last_failure_at = EXCLUDED.last_failure_at
+RETURNING *;
+
+-- name: LockLogin :execrows
+UPDATE login_throttles SET locked_until = sqlc.arg(locked_until)::timestamp
+WHERE throttle_key = sqlc.arg(throttle_key)
+  AND (locked_until IS NULL OR locked_until < sqlc.arg(now)::timestamp);
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles WHERE throttle_key = $1;
+
+-- name: DeleteStaleLoginThrottles :exec
+DELETE FROM login_throttles
+WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1);
@@ -0,0 +1,20 @@
+-- name: CreateMute :exec
+INSERT INTO mutes (muter_id, muted_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteMute :exec
+DELETE FROM mutes WHERE muter_id = $1 AND muted_id = $2;
+
+-- name: ListMutes :many
+SELECT * FROM mutes WHERE muter_id = $1
+ORDER BY created_at DESC;
+
+-- name: IsMuted :one
+SELECT EXISTS (
+    SELECT 1 FROM mutes WHERE muter_id = $1 AND muted_id = $2
+);
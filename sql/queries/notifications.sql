@@ -0,0 +1,48 @@
+-- name: CreateNotification :one
+INSERT INTO notifications (id, created_at, updated_at, user_id, kind, group_key, actor_id, chirp_id, actor_count)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    1
+)
+ON CONFLICT (user_id, group_key) WHERE read_at IS NULL
+DO UPDATE SET actor_id = EXCLUDED.actor_id,
+              updated_at = NOW()
+RETURNING *;
+
+-- name: AddNotificationActor :exec
+INSERT INTO notification_actors (notification_id, actor_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: RecountNotificationActors :one
+UPDATE notifications
+SET actor_count = (SELECT COUNT(*) FROM notification_actors WHERE notification_id = notifications.id)
+WHERE id = $1
+RETURNING *;
+
+-- name: ListNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg(user_id)
+  AND (created_at, id) < (sqlc.arg(before_time)::timestamp, sqlc.arg(before_id)::uuid)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE user_id = sqlc.arg(user_id) AND read_at IS NULL AND id = ANY(sqlc.arg(ids)::uuid[]);
+
+-- name: MarkAllNotificationsRead :exec
+UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: NotifyNotification :exec
+SELECT pg_notify('notifications', sqlc.arg(payload)::text);
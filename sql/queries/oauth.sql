@@ -0,0 +1,107 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, owner_id, name, redirect_uris, secret_hash)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients WHERE owner_id = $1
+ORDER BY created_at;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1 AND owner_id = $2;
+
+-- name: UpsertOAuthGrant :exec
+INSERT INTO oauth_grants (client_id, user_id, created_at, updated_at, scopes)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    NOW(),
+    $3
+)
+ON CONFLICT (client_id, user_id) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW();
+
+-- name: GetOAuthGrant :one
+SELECT * FROM oauth_grants WHERE client_id = $1 AND user_id = $2;
+
+-- name: ListOAuthGrants :many
+SELECT oauth_grants.client_id, oauth_clients.name, oauth_grants.scopes, oauth_grants.created_at, oauth_grants.updated_at
+FROM oauth_grants
+JOIN oauth_clients ON oauth_clients.id = oauth_grants.client_id
+WHERE oauth_grants.user_id = $1
+ORDER BY oauth_grants.created_at;
+
+-- name: DeleteOAuthGrant :execrows
+DELETE FROM oauth_grants WHERE client_id = $1 AND user_id = $2;
+
+-- name: CreateOAuthCode :exec
+INSERT INTO oauth_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, family_id, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
+);
+
+-- name: GetOAuthCode :one
+SELECT * FROM oauth_codes WHERE code_hash = $1;
+
+-- name: UseOAuthCode :execrows
+UPDATE oauth_codes SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL;
+
+-- name: DeleteOAuthCodes :exec
+DELETE FROM oauth_codes WHERE client_id = $1 AND user_id = $2;
+
+-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, family_id, client_id, user_id, scopes, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: GetOAuthToken :one
+SELECT * FROM oauth_tokens WHERE token_hash = $1;
+
+-- name: RotateOAuthToken :execrows
+UPDATE oauth_tokens SET revoked_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOAuthFamily :exec
+UPDATE oauth_tokens SET revoked_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOAuthGrantTokens :exec
+UPDATE oauth_tokens SET revoked_at = NOW()
+WHERE client_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeUserOAuthTokens :exec
+UPDATE oauth_tokens SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: OAuthFamilyActive :one
+SELECT EXISTS (
+    SELECT 1 FROM oauth_tokens
+    WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+);
@@ -0,0 +1,47 @@
+-- name: CreateOIDCLogin :exec
+INSERT INTO oidc_logins (state_hash, created_at, provider, nonce, code_verifier, user_id, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: TakeOIDCLogin :one
+DELETE FROM oidc_logins WHERE state_hash = $1
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLogins :exec
+DELETE FROM oidc_logins WHERE expires_at < NOW();
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (provider, subject, user_id, email, created_at, last_login_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW(),
+    NOW()
+)
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE provider = $1 AND subject = $2;
+
+-- name: ListUserIdentities :many
+SELECT * FROM user_identities WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities SET email = $3, last_login_at = NOW()
+WHERE provider = $1 AND subject = $2;
+
+-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities WHERE user_id = $1 AND provider = $2;
+
+-- name: CountUserIdentities :one
+SELECT COUNT(*) FROM user_identities WHERE user_id = $1;
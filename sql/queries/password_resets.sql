@@ -0,0 +1,23 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, created_at, user_id, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+);
+
+-- name: GetPasswordReset :one
+SELECT * FROM password_resets WHERE token_hash = $1;
+
+-- name: UsePasswordReset :execrows
+UPDATE password_resets SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW();
+
+-- name: ExpirePasswordResets :exec
+UPDATE password_resets SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: CountPasswordResetsSince :one
+SELECT COUNT(*) FROM password_resets
+WHERE user_id = $1 AND created_at > $2;
@@ -0,0 +1,64 @@
+-- name: DeleteRecommendations :exec
+DELETE FROM recommendations;
+
+-- name: ComputeRecommendations :exec
+WITH hashtags AS (
+    SELECT DISTINCT user_id, LOWER((regexp_matches(body, '#([A-Za-z0-9_]+)', 'g'))[1]) AS tag
+    FROM chirps
+    WHERE created_at > NOW() - INTERVAL '30 days'
+),
+candidates AS (
+    -- followed by people the user follows
+    SELECT f1.follower_id AS user_id, f2.followee_id AS candidate_id, 1.0 AS score, 'followed_by_friends' AS reason
+    FROM follows f1
+    JOIN follows f2 ON f2.follower_id = f1.followee_id
+    UNION ALL
+    -- chirps about the same hashtags
+    SELECT a.user_id, b.user_id, 0.5, 'shared_hashtags'
+    FROM hashtags a
+    JOIN hashtags b ON b.tag = a.tag AND b.user_id <> a.user_id
+    UNION ALL
+    -- followed by people who follow the same accounts as the user
+    SELECT f1.follower_id, f3.followee_id, 0.2, 'popular_with_similar'
+    FROM follows f1
+    JOIN follows f2 ON f2.followee_id = f1.followee_id AND f2.follower_id <> f1.follower_id
+    JOIN follows f3 ON f3.follower_id = f2.follower_id
+),
+scored AS (
+    SELECT user_id, candidate_id, SUM(score) AS score, (ARRAY_AGG(reason ORDER BY score DESC))[1] AS reason
+    FROM candidates
+    WHERE user_id <> candidate_id
+      AND NOT EXISTS (SELECT 1 FROM follows WHERE follower_id = candidates.user_id AND followee_id = candidates.candidate_id)
+      AND NOT EXISTS (
+          SELECT 1 FROM blocks
+          WHERE (blocker_id = candidates.user_id AND blocked_id = candidates.candidate_id)
+             OR (blocker_id = candidates.candidate_id AND blocked_id = candidates.user_id)
+      )
+      AND NOT EXISTS (SELECT 1 FROM mutes WHERE muter_id = candidates.user_id AND muted_id = candidates.candidate_id)
+    GROUP BY user_id, candidate_id
+),
+ranked AS (
+    SELECT *, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY score DESC) AS position
+    FROM scored
+)
+INSERT INTO recommendations (user_id, recommended_id, score, reason, computed_at)
+SELECT user_id, candidate_id, score, reason, NOW()
+FROM ranked
+WHERE position <= sqlc.arg(per_user);
+
+-- name: ListRecommendations :many
+SELECT users.*, recommendations.score, recommendations.reason FROM recommendations
+JOIN users ON users.id = recommendations.recommended_id
+WHERE recommendations.user_id = $1
+  AND NOT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = users.id)
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocker_id = $1 AND blocked_id = users.id)
+         OR (blocker_id = users.id AND blocked_id = $1)
+  )
+  AND NOT EXISTS (SELECT 1 FROM mutes WHERE muter_id = $1 AND muted_id = users.id)
+ORDER BY recommendations.score DESC
+LIMIT $2;
+
+-- name: TryLockRecommendations :one
+SELECT pg_try_advisory_xact_lock(hashtext('recommendations')) AS locked;
@@ -0,0 +1,18 @@
+-- name: CreateSigningKey :one
+INSERT INTO signing_keys (kid, created_at, algorithm, private_key, replaces_secret)
+VALUES ($1, NOW(), $2, $3, NOT EXISTS (SELECT 1 FROM signing_keys))
+RETURNING *;
+
+-- name: ListSigningKeys :many
+SELECT * FROM signing_keys
+WHERE retired_at IS NULL OR retired_at > sqlc.arg(retired_since)::timestamp
+ORDER BY retired_at DESC NULLS FIRST, created_at DESC;
+
+-- name: RetireSigningKey :exec
+UPDATE signing_keys SET retired_at = sqlc.arg(retired_at)::timestamp WHERE kid = sqlc.arg(kid);
+
+-- name: DeleteSigningKeysRetiredBefore :exec
+DELETE FROM signing_keys WHERE retired_at < $1;
+
+-- name: NotifySigningKeys :exec
+SELECT pg_notify('signing_keys', '');
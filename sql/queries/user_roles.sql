@@ -0,0 +1,10 @@
+-- name: ListUserRoles :many
+SELECT * FROM user_roles WHERE user_id = $1 ORDER BY role;
+
+-- name: GrantUserRole :execrows
+INSERT INTO user_roles (user_id, role, granted_at, granted_by)
+VALUES ($1, $2, NOW(), $3)
+ON CONFLICT (user_id, role) DO NOTHING;
+
+-- name: RevokeUserRole :execrows
+DELETE FROM user_roles WHERE user_id = $1 AND role = $2;
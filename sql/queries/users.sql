@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -16,8 +17,75 @@ DELETE FROM users;
 SELECT * FROM users WHERE email = $1;
 
 -- name: UserUpdate :one
-UPDATE users SET email = $2, hashed_password = $3 WHERE id = $1
+UPDATE users
+SET email = $2, hashed_password = $3,
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
+WHERE id = $1
 RETURNING *;
 
 -- name: UpgradeUser :exec
 UPDATE users SET is_chirpy_red = TRUE WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE LOWER(handle) = LOWER($1);
+
+-- name: UpdateProfile :one
+UPDATE users SET handle = $2, display_name = $3, bio = $4, avatar = $5, is_private = $6, updated_at = NOW() WHERE id = $1
+RETURNING *;
+
+-- name: SearchUsers :many
+SELECT users.* FROM users
+LEFT JOIN follower_counts ON follower_counts.user_id = users.id
+WHERE (LOWER(users.handle) LIKE sqlc.arg(prefix)
+       OR LOWER(users.display_name) LIKE sqlc.arg(prefix)
+       OR (sqlc.arg(fuzzy)::boolean
+           AND (LOWER(users.handle) % sqlc.arg(query)::text
+                OR LOWER(users.display_name) % sqlc.arg(query)::text)))
+  AND NOT EXISTS (
+      SELECT 1 FROM blocks
+      WHERE (blocks.blocker_id = users.id AND blocks.blocked_id = sqlc.arg(viewer_id))
+         OR (blocks.blocker_id = sqlc.arg(viewer_id) AND blocks.blocked_id = users.id)
+  )
+ORDER BY
+    LOWER(users.handle) LIKE sqlc.arg(prefix) DESC,
+    EXISTS (
+        SELECT 1 FROM follows
+        WHERE follows.follower_id = sqlc.arg(viewer_id) AND follows.followee_id = users.id
+    ) DESC,
+    COALESCE(follower_counts.followers, 0) DESC,
+    GREATEST(similarity(LOWER(users.handle), sqlc.arg(query)::text), similarity(LOWER(users.display_name), sqlc.arg(query)::text)) DESC,
+    users.handle
+LIMIT sqlc.arg(max_results);
+
+-- name: MarkEmailVerified :execrows
+UPDATE users SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2 AND email_verified_at IS NULL;
+
+-- name: UpdatePassword :exec
+UPDATE users SET hashed_password = $2, updated_at = NOW() WHERE id = $1;
+
+-- name: RehashPassword :execrows
+UPDATE users SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hash);
+
+-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret = $2, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1 AND totp_enabled_at IS NULL;
+
+-- name: EnableTOTP :exec
+UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW() WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW() WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2;
+
+-- name: RevokeUserTokens :exec
+UPDATE users SET tokens_valid_after = sqlc.arg(valid_after)::timestamp WHERE id = sqlc.arg(id);
+
+-- name: GetTokensValidAfter :one
+SELECT tokens_valid_after FROM users WHERE id = $1;
@@ -0,0 +1,86 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, user_id, url, secret, events)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints WHERE id = $1;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: DeleteWebhookEndpoint :exec
+DELETE FROM webhook_endpoints WHERE id = $1;
+
+-- name: EnableWebhookEndpoint :exec
+UPDATE webhook_endpoints SET disabled_at = NULL, consecutive_failures = 0, updated_at = NOW() WHERE id = $1;
+
+-- name: EnqueueWebhookDeliveries :exec
+INSERT INTO webhook_deliveries (id, created_at, updated_at, endpoint_id, event, payload, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), NOW(), webhook_endpoints.id, sqlc.arg(event)::text, sqlc.arg(payload)::text, NOW()
+FROM webhook_endpoints
+WHERE webhook_endpoints.user_id = sqlc.arg(user_id)
+  AND webhook_endpoints.disabled_at IS NULL
+  AND sqlc.arg(event)::text = ANY(webhook_endpoints.events);
+
+-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries SET next_attempt_at = sqlc.arg(lease_until)::timestamp, updated_at = NOW()
+WHERE webhook_deliveries.id IN (
+    SELECT d.id FROM webhook_deliveries d
+    WHERE d.status = 'pending' AND d.next_attempt_at <= NOW()
+    ORDER BY d.next_attempt_at
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: RecordWebhookAttempt :exec
+INSERT INTO webhook_attempts (id, created_at, delivery_id, response_code, error, duration_ms)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: MarkWebhookDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'succeeded', attempts = attempts + 1, last_response_code = $2, last_error = '', updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookFailed :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, last_response_code = $3, last_error = $4,
+    next_attempt_at = $5, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ResetWebhookFailures :exec
+UPDATE webhook_endpoints SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0;
+
+-- name: RecordWebhookFailure :one
+UPDATE webhook_endpoints
+SET consecutive_failures = consecutive_failures + 1,
+    disabled_at = CASE WHEN consecutive_failures + 1 >= sqlc.arg(max_failures)::integer THEN NOW() ELSE disabled_at END,
+    updated_at = NOW()
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ListWebhookAttempts :many
+SELECT * FROM webhook_attempts WHERE delivery_id = $1
+ORDER BY created_at;
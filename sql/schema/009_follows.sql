@@ -0,0 +1,27 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN is_private BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE follows (
+    follower_id UUID NOT NULL,
+    followee_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (follower_id, followee_id),
+    FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (followee_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+CREATE INDEX follows_followee_id_idx ON follows (followee_id);
+
+CREATE TABLE follow_requests (
+    requester_id UUID NOT NULL,
+    target_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (requester_id, target_id),
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (target_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+CREATE INDEX follow_requests_target_id_idx ON follow_requests (target_id);
+
+-- +goose Down
+DROP TABLE follow_requests;
+DROP TABLE follows;
+ALTER TABLE users DROP COLUMN is_private;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE recommendations (
+    user_id UUID NOT NULL,
+    recommended_id UUID NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    reason TEXT NOT NULL,
+    computed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, recommended_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (recommended_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+-- +goose Down
+DROP TABLE recommendations;
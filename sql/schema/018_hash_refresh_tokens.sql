@@ -0,0 +1,13 @@
+-- +goose Up
+-- Tokens are stored as the hex SHA-256 of what the client holds. Hashing the
+-- existing rows in place keeps current sessions working: clients still
+-- present the raw token, which now hashes to the stored value.
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+UPDATE refresh_tokens SET token_hash = encode(sha256(token_hash::bytea), 'hex');
+UPDATE refresh_tokens SET replaced_by = encode(sha256(replaced_by::bytea), 'hex')
+WHERE replaced_by IS NOT NULL;
+
+-- +goose Down
+-- the raw tokens can't be recovered, so going back logs everyone out
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
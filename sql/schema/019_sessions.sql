@@ -0,0 +1,15 @@
+-- +goose Up
+-- a session is a refresh token family; its active token carries the details
+ALTER TABLE refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN session_started_at TIMESTAMP NULL;
+ALTER TABLE refresh_tokens ADD COLUMN last_used_at TIMESTAMP NULL;
+UPDATE refresh_tokens SET session_started_at = created_at, last_used_at = updated_at;
+ALTER TABLE refresh_tokens ALTER COLUMN session_started_at SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN session_started_at;
+ALTER TABLE refresh_tokens DROP COLUMN ip;
+ALTER TABLE refresh_tokens DROP COLUMN user_agent;
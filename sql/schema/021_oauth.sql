@@ -0,0 +1,65 @@
+-- +goose Up
+-- third-party apps; public clients have no secret and rely on PKCE alone
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    owner_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    secret_hash TEXT NULL DEFAULT NULL,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+CREATE INDEX oauth_clients_owner_id_idx ON oauth_clients (owner_id);
+
+-- what a user has consented to let an app do
+CREATE TABLE oauth_grants (
+    client_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    scopes TEXT[] NOT NULL,
+    PRIMARY KEY (client_id, user_id),
+    FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+CREATE INDEX oauth_grants_user_id_idx ON oauth_grants (user_id);
+
+-- family_id is the token family the code is exchanged into, so a replayed
+-- code can revoke what the first exchange issued
+CREATE TABLE oauth_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    client_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL,
+    family_id UUID NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+-- refresh tokens issued to apps; access tokens are JWTs carrying family_id
+CREATE TABLE oauth_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    family_id UUID NOT NULL,
+    client_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+CREATE INDEX oauth_tokens_family_id_idx ON oauth_tokens (family_id);
+CREATE INDEX oauth_tokens_grant_idx ON oauth_tokens (client_id, user_id);
+
+-- +goose Down
+DROP TABLE oauth_tokens;
+DROP TABLE oauth_codes;
+DROP TABLE oauth_grants;
+DROP TABLE oauth_clients;
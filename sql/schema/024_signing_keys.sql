@@ -0,0 +1,13 @@
+-- +goose Up
+-- asymmetric keys access tokens are signed with; the newest unretired key
+-- signs, retired ones still verify tokens for a while
+CREATE TABLE signing_keys (
+    kid TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    algorithm TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    retired_at TIMESTAMP NULL DEFAULT NULL
+    );
+
+-- +goose Down
+DROP TABLE signing_keys;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- access tokens issued before this are refused, e.g. after a password change
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP NULL DEFAULT NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN tokens_valid_after;
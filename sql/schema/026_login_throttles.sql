@@ -0,0 +1,12 @@
+-- +goose Up
+-- failed logins per "account:<email>" and per "ip:<address>", for backoff
+-- and lockouts; emails are tracked whether or not they have an account
+CREATE TABLE login_throttles (
+    throttle_key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NULL DEFAULT NULL
+    );
+
+-- +goose Down
+DROP TABLE login_throttles;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- the first key made while tokens were still HS256; until it's been around
+-- for the overlap, HS256 tokens keep verifying so switching logs nobody out
+ALTER TABLE signing_keys ADD COLUMN replaces_secret BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE signing_keys DROP COLUMN replaces_secret;
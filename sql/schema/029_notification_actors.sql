@@ -0,0 +1,21 @@
+-- +goose Up
+-- who a grouped notification is from, so actor_count counts people rather
+-- than events
+CREATE TABLE notification_actors (
+    notification_id UUID NOT NULL,
+    actor_id UUID NOT NULL,
+    PRIMARY KEY (notification_id, actor_id),
+    FOREIGN KEY (notification_id) REFERENCES notifications(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+INSERT INTO notification_actors (notification_id, actor_id)
+SELECT id, actor_id FROM notifications WHERE actor_id IS NOT NULL;
+
+-- pages on created_at, which a folded event doesn't move
+DROP INDEX notifications_user_id_updated_at_idx;
+CREATE INDEX notifications_user_id_created_at_idx ON notifications (user_id, created_at DESC, id DESC);
+
+-- +goose Down
+DROP INDEX notifications_user_id_created_at_idx;
+CREATE INDEX notifications_user_id_updated_at_idx ON notifications (user_id, updated_at DESC, id DESC);
+DROP TABLE notification_actors;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- GET /api/users/verify shadows a profile with this handle, so anyone who
+-- claimed it before it was reserved gets a default handle instead
+UPDATE users SET handle = 'user_' || substr(md5(random()::text), 1, 12), updated_at = NOW()
+WHERE LOWER(handle) = 'verify';
+
+-- +goose Down
@@ -0,0 +1,38 @@
+-- +goose Up
+-- follower counts kept up to date by trigger, so ranking search results by
+-- popularity is a key lookup rather than a count per candidate
+CREATE TABLE follower_counts (
+    user_id UUID PRIMARY KEY,
+    followers BIGINT NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+INSERT INTO follower_counts (user_id, followers)
+SELECT followee_id, COUNT(*) FROM follows GROUP BY followee_id;
+
+-- +goose StatementBegin
+CREATE FUNCTION follows_count_followers() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO follower_counts (user_id, followers) VALUES (NEW.followee_id, 1)
+        ON CONFLICT (user_id) DO UPDATE SET followers = follower_counts.followers + 1;
+    ELSE
+        UPDATE follower_counts SET followers = followers - 1 WHERE user_id = OLD.followee_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER follows_count_followers AFTER INSERT OR DELETE ON follows
+FOR EACH ROW EXECUTE FUNCTION follows_count_followers();
+
+-- prefix matches for queries too short for trigrams
+CREATE INDEX users_handle_prefix_idx ON users (LOWER(handle) text_pattern_ops);
+CREATE INDEX users_display_name_prefix_idx ON users (LOWER(display_name) text_pattern_ops);
+
+-- +goose Down
+DROP INDEX users_display_name_prefix_idx;
+DROP INDEX users_handle_prefix_idx;
+DROP TRIGGER follows_count_followers ON follows;
+DROP FUNCTION follows_count_followers();
+DROP TABLE follower_counts;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- whether the author was private when the event happened, so live streams
+-- can hide it from non-followers without loading every private account
+ALTER TABLE chirp_events ADD COLUMN author_private BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE chirp_events SET author_private = users.is_private
+FROM users WHERE users.id = chirp_events.user_id;
+
+-- +goose Down
+ALTER TABLE chirp_events DROP COLUMN author_private;
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/stream"
+)
+
+const (
+	chirpEventsChannel   = "chirp_events"
+	chirpEventsRetention = 24 * time.Hour
+	maxReplayEvents      = 1000
+	streamBuffer         = 64
+	streamHeartbeat      = 15 * time.Second
+	// how soon blocks, mutes and follows reach an open stream
+	streamHiddenRefresh = 10 * time.Second
+	streamWriteTimeout  = 10 * time.Second
+)
+
+var hashtagPattern = regexp.MustCompile(`#([A-Za-z0-9_]+)`)
+
+func hasHashtag(body, tag string) bool {
+	for _, m := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+		if strings.EqualFold(m[1], tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func eventFromDB(e database.ChirpEvent) stream.Event {
+	return stream.Event{
+		ID:            e.ID,
+		CreatedAt:     e.CreatedAt,
+		Type:          stream.EventType(e.Type),
+		ChirpID:       e.ChirpID,
+		AuthorID:      e.UserID,
+		Body:          e.Body,
+		AuthorPrivate: e.AuthorPrivate,
+	}
+}
+
+// publishChirpEvent records a chirp change and announces it to every
+// instance through NOTIFY. Subscribers, including this instance's, pick it
+// up in listenChirpEvents. Failures are logged so they never fail the write
+// that caused them.
+func (cfg *apiConfig) publishChirpEvent(ctx context.Context, eventType stream.EventType, c database.Chirp) {
+	row, err := cfg.queries.CreateChirpEvent(ctx, database.CreateChirpEventParams{
+		Type:    string(eventType),
+		ChirpID: c.ID,
+		UserID:  c.UserID,
+		Body:    c.Body,
+	})
+	if err != nil {
+		log.Printf("couldn't record %s event: %s", eventType, err)
+		return
+	}
+
+	payload, err := json.Marshal(eventFromDB(row))
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		return
+	}
+	err = cfg.queries.NotifyChirpEvent(ctx, string(payload))
+	if err != nil {
+		log.Printf("couldn't notify %s event: %s", eventType, err)
+	}
+}
+
+// listenEvents feeds chirp events from Postgres into the local broker and
+// notifications into the WebSocket hub. After a dropped connection it
+// backfills the chirp events it missed from the chirp_events table. It also
+// prunes chirp events older than the retention.
+func (cfg *apiConfig) listenEvents(dbURL string) {
+	listener := pq.NewListener(dbURL, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("chirp event listener: %s", err)
+		}
+	})
+	if err := listener.Listen(chirpEventsChannel); err != nil {
+		log.Printf("couldn't listen for chirp events: %s", err)
+	}
+	if err := listener.Listen(notificationsChannel); err != nil {
+		log.Printf("couldn't listen for notifications: %s", err)
+	}
+	if err := listener.Listen(signingKeysChannel); err != nil {
+		log.Printf("couldn't listen for signing key rotations: %s", err)
+	}
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	seen := stream.NewTracker(maxReplayEvents)
+	for {
+		select {
+		case n := <-listener.Notify:
+			// a nil notification means the connection was re-established
+			if n == nil {
+				cfg.backfillChirpEvents(seen)
+				cfg.reloadSigningKeys()
+				continue
+			}
+			if n.Channel == signingKeysChannel {
+				cfg.reloadSigningKeys()
+				continue
+			}
+			if n.Channel == notificationsChannel {
+				cfg.handleNotificationEvent(n.Extra)
+				continue
+			}
+			var e stream.Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("couldn't decode chirp event: %s", err)
+				continue
+			}
+			seen.Start(e.ID - 1)
+			if seen.Add(e.ID) {
+				cfg.broker.Publish(e)
+			}
+		case <-prune.C:
+			err := cfg.queries.DeleteChirpEventsBefore(context.Background(), time.Now().Add(-chirpEventsRetention))
+			if err != nil {
+				log.Printf("couldn't prune chirp events: %s", err)
+			}
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+// backfillChirpEvents publishes the events committed since the last one
+// seen without a gap before it, skipping those already published.
+func (cfg *apiConfig) backfillChirpEvents(seen *stream.Tracker) {
+	if seen.Floor() == 0 {
+		return
+	}
+	events, err := cfg.queries.ListChirpEventsAfter(context.Background(), database.ListChirpEventsAfterParams{
+		ID:    seen.Floor(),
+		Limit: maxReplayEvents,
+	})
+	if err != nil {
+		log.Printf("couldn't backfill chirp events: %s", err)
+		return
+	}
+	for _, e := range events {
+		if seen.Add(e.ID) {
+			cfg.broker.Publish(eventFromDB(e))
+		}
+	}
+}
+
+// chirpEventData is the payload clients get for an event: the chirp itself,
+// or just its id and author once it's deleted.
+func chirpEventData(e stream.Event) any {
+	if e.Type == stream.EventChirpDeleted {
+		type deleted struct {
+			ID     uuid.UUID `json:"id"`
+			UserID uuid.UUID `json:"user_id"`
+		}
+		return deleted{ID: e.ChirpID, UserID: e.AuthorID}
+	}
+	return chirpFromDB(database.Chirp{
+		ID:        e.ChirpID,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.CreatedAt,
+		Body:      e.Body,
+		UserID:    e.AuthorID,
+	})
+}
+
+func writeStreamEvent(w http.ResponseWriter, e stream.Event) error {
+	dat, err := json.Marshal(chirpEventData(e))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, dat)
+	return err
+}
+
+// streamChirps is a Server-Sent Events feed of chirp changes. It takes the
+// same author_id filter as GET /api/chirps plus a hashtag filter, and
+// resumes after Last-Event-ID. Subscribers that can't keep up are
+// disconnected and are expected to reconnect with Last-Event-ID.
+func (cfg *apiConfig) streamChirps(w http.ResponseWriter, r *http.Request) {
+	var authorID uuid.UUID
+	if s := r.URL.Query().Get("author_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, 400, "Invalid author_id")
+			return
+		}
+		authorID = id
+	}
+	tag := strings.TrimPrefix(r.URL.Query().Get("hashtag"), "#")
+
+	var lastID int64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			respondWithError(w, 400, "Invalid Last-Event-ID")
+			return
+		}
+		lastID = id
+	}
+
+	viewer := cfg.viewerID(r)
+	filter, err := cfg.loadAuthorFilter(context.Background(), viewer)
+	if err != nil {
+		log.Printf("couldn't load author filter: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	matches := func(e stream.Event) bool {
+		if authorID != uuid.Nil && e.AuthorID != authorID {
+			return false
+		}
+		return tag == "" || hasHashtag(e.Body, tag)
+	}
+
+	// subscribe before replaying so nothing published in between is lost
+	sub := cfg.broker.Subscribe(matches, streamBuffer)
+	defer cfg.broker.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	// events can commit out of id order, so the replay and the live feed
+	// overlap by id rather than at a single point
+	replayed := map[int64]bool{}
+	send := func(e stream.Event) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := writeStreamEvent(w, e); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("streaming not supported: %s", err)
+		return
+	}
+
+	if lastID > 0 {
+		missed, err := cfg.queries.ListChirpEventsAfter(context.Background(), database.ListChirpEventsAfterParams{
+			ID:    lastID,
+			Limit: maxReplayEvents,
+		})
+		if err != nil {
+			log.Printf("couldn't replay chirp events: %s", err)
+			return
+		}
+		for _, row := range missed {
+			e := eventFromDB(row)
+			replayed[e.ID] = true
+			if !matches(e) || !filter.allows(e) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	refresh := time.NewTicker(streamHiddenRefresh)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				log.Printf("dropping slow chirp stream subscriber")
+				return
+			}
+			// already sent during the replay
+			if replayed[e.ID] || !filter.allows(e) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-refresh.C:
+			// pick up blocks, mutes and follows made since connecting
+			f, err := cfg.loadAuthorFilter(context.Background(), viewer)
+			if err != nil {
+				log.Printf("couldn't refresh author filter: %s", err)
+				continue
+			}
+			filter = f
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/database"
+)
+
+const (
+	totpIssuer         = "Chirpy"
+	twoFactorChallenge = 5 * time.Minute
+	recoveryCodeCount  = 10
+	totpQRCodeSize     = 256
+)
+
+// respondWithChallenge answers a correct password for a 2FA account with a
+// short-lived challenge instead of tokens.
+func (cfg *apiConfig) respondWithChallenge(w http.ResponseWriter, user database.User) {
+	challenge, err := auth.MakeSignedToken(user.ID, auth.TokenTypeTwoFactorChallenge, cfg.secretPhrase, twoFactorChallenge)
+	if err != nil {
+		log.Printf("couldn't make 2fa challenge: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+	respondWithBody(w, 200, response{TwoFactorRequired: true, ChallengeToken: challenge})
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+// A TOTP code is only good once: a code from a time step at or before the
+// last accepted one is refused, even while it's still current.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, user database.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		n, err := cfg.queries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+		})
+		return n == 1, err
+	}
+
+	if !user.TotpSecret.Valid {
+		return false, nil
+	}
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	n, err := cfg.queries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		ID:           user.ID,
+		TotpLastStep: step,
+	})
+	return n == 1, err
+}
+
+// makeRecoveryCodes replaces the user's recovery codes. The codes are
+// returned once; only their hashes are kept.
+func (cfg *apiConfig) makeRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.queries.WithTx(tx)
+	if err := qtx.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes := []string{}
+	for range recoveryCodeCount {
+		code, err := auth.MakeRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		err = qtx.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashToken(code),
+			UserID:   userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, tx.Commit()
+}
+
+// enrollTOTP starts 2FA enrollment with a fresh secret. 2FA stays off until
+// the user proves their app works in confirmTOTP.
+func (cfg *apiConfig) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("couldn't generate totp secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.queries.SetTOTPSecret(context.Background(), database.SetTOTPSecretParams{
+		ID:         caller,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		log.Printf("couldn't save totp secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	uri := auth.TOTPURI(totpIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, totpQRCodeSize)
+	if err != nil {
+		log.Printf("couldn't make qr code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+		QRCode     string `json:"qr_code"`
+	}
+	respondWithBody(w, 200, response{
+		Secret:     secret,
+		OtpauthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// confirmTOTP turns 2FA on once the user enters a first code from their
+// app, and returns their recovery codes.
+func (cfg *apiConfig) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, 400, "Start enrollment first")
+		return
+	}
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	codes, err := cfg.makeRecoveryCodes(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't make recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.queries.EnableTOTP(context.Background(), database.EnableTOTPParams{
+		ID:           caller,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		log.Printf("couldn't enable totp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithBody(w, 200, response{RecoveryCodes: codes})
+}
+
+// confirmSecondFactor runs check for a signed-in user changing their 2FA
+// settings. Codes are short, so failures count against the login
+// throttles and a throttled user isn't checked at all; every failure gets
+// the same message. It answers the request unless it reports true.
+func (cfg *apiConfig) confirmSecondFactor(w http.ResponseWriter, r *http.Request, user database.User, message string, check func(context.Context) (bool, error)) bool {
+	ctx := context.Background()
+	wait, err := cfg.loginWait(ctx, r, user.Email)
+	if err != nil {
+		log.Printf("couldn't check login throttle: %s", err)
+		w.WriteHeader(500)
+		return false
+	}
+	if wait > 0 {
+		respondWithLoginWait(w, wait)
+		return false
+	}
+
+	ok, err := check(ctx)
+	if err != nil {
+		log.Printf("couldn't check second factor: %s", err)
+		w.WriteHeader(500)
+		return false
+	}
+	if !ok {
+		cfg.recordLoginFailure(ctx, r, user.Email, &user)
+		respondWithError(w, 401, message)
+		return false
+	}
+	cfg.clearLoginFailures(ctx, user.Email)
+	return true
+}
+
+// disableTOTP needs the password, if the user has one, and a second factor,
+// so a stolen access token alone can't turn 2FA off.
+func (cfg *apiConfig) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, 400, "Two-factor authentication isn't enabled")
+		return
+	}
+	ok := cfg.confirmSecondFactor(w, r, user, "Incorrect password or code", func(ctx context.Context) (bool, error) {
+		// users who only sign in through a provider have no password to
+		// give; the code isn't spent unless the password matches
+		if hasPassword(user) && !auth.ComparePassword(params.Password, user.HashedPassword) {
+			return false, nil
+		}
+		return cfg.checkSecondFactor(ctx, user, params.Code, params.RecoveryCode)
+	})
+	if !ok {
+		return
+	}
+
+	err = cfg.queries.DisableTOTP(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't disable totp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.queries.DeleteRecoveryCodes(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't delete recovery codes: %s", err)
+	}
+	w.WriteHeader(204)
+}
+
+// regenerateRecoveryCodes replaces every recovery code, used or not.
+func (cfg *apiConfig) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.queries.GetUserByID(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't get user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, 400, "Two-factor authentication isn't enabled")
+		return
+	}
+	ok := cfg.confirmSecondFactor(w, r, user, "Invalid code", func(ctx context.Context) (bool, error) {
+		return cfg.checkSecondFactor(ctx, user, params.Code, "")
+	})
+	if !ok {
+		return
+	}
+
+	codes, err := cfg.makeRecoveryCodes(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't make recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithBody(w, 200, response{RecoveryCodes: codes})
+}
+
+// loginTwoFactor completes a login that loginUser answered with a
+// challenge, given a code from the user's app or a recovery code.
+func (cfg *apiConfig) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	userID, err := auth.ValidateSignedToken(params.ChallengeToken, auth.TokenTypeTwoFactorChallenge, cfg.secretPhrase)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired challenge")
+		return
+	}
+	user, err := cfg.queries.GetUserByID(context.Background(), userID)
+	if err != nil || !user.TotpEnabledAt.Valid {
+		respondWithError(w, 401, "Invalid or expired challenge")
+		return
+	}
+
+	// codes are short, so guesses count against the account like passwords
+	wait, err := cfg.loginWait(context.Background(), r, user.Email)
+	if err != nil {
+		log.Printf("couldn't check login throttle: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if wait > 0 {
+		respondWithLoginWait(w, wait)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(context.Background(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		log.Printf("couldn't check second factor: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(context.Background(), r, user.Email, &user)
+		respondWithError(w, 401, "Invalid code")
+		return
+	}
+	cfg.clearLoginFailures(context.Background(), user.Email)
+
+	cfg.completeLogin(w, r, user)
+}
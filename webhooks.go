@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tnaums/chirpy/internal/database"
+	"github.com/tnaums/chirpy/internal/stream"
+	"github.com/tnaums/chirpy/internal/webhook"
+)
+
+// Webhook events. The chirp events reuse the live stream's names.
+const (
+	WebhookChirpCreated = string(stream.EventChirpCreated)
+	WebhookChirpDeleted = string(stream.EventChirpDeleted)
+	WebhookUserFollowed = "user.followed"
+)
+
+var webhookEvents = map[string]bool{
+	WebhookChirpCreated: true,
+	WebhookChirpDeleted: true,
+	WebhookUserFollowed: true,
+}
+
+const (
+	maxWebhooksPerUser = 10
+
+	webhookPollPeriod   = 5 * time.Second
+	webhookBatchSize    = 20
+	webhookLease        = 2 * time.Minute
+	webhookTimeout      = 10 * time.Second
+	webhookBackoffBase  = 30 * time.Second
+	webhookBackoffMax   = 6 * time.Hour
+	maxWebhookAttempts  = 10
+	maxWebhookFailures  = 20
+	maxWebhookErrorSize = 500
+
+	defaultWebhookDeliveries = 20
+	maxWebhookDeliveries     = 100
+)
+
+// newWebhookClient makes the client deliveries go out on. Outside dev it
+// only connects to public addresses, checked at dial time so DNS can't be
+// used to point it back inside our network; dev allows the local test
+// receiver.
+func newWebhookClient(platform string) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if platform != "dev" {
+		dialer.Control = webhook.DialControl
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			// no proxy: it would make the connection the check sees
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		// a redirect could point the signed payload anywhere
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type Webhook struct {
+	ID                  uuid.UUID  `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Secret              string     `json:"secret,omitempty"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+}
+
+type WebhookAttempt struct {
+	CreatedAt    time.Time `json:"created_at"`
+	ResponseCode *int32    `json:"response_code"`
+	Error        string    `json:"error,omitempty"`
+	DurationMs   int32     `json:"duration_ms"`
+}
+
+type WebhookDelivery struct {
+	ID               uuid.UUID        `json:"id"`
+	CreatedAt        time.Time        `json:"created_at"`
+	Event            string           `json:"event"`
+	Status           string           `json:"status"`
+	Attempts         int32            `json:"attempts"`
+	NextAttemptAt    *time.Time       `json:"next_attempt_at,omitempty"`
+	LastResponseCode *int32           `json:"last_response_code"`
+	LastError        string           `json:"last_error,omitempty"`
+	Log              []WebhookAttempt `json:"log"`
+}
+
+// webhookPayload is the body POSTed to endpoints.
+type webhookPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+func webhookFromDB(e database.WebhookEndpoint) Webhook {
+	hook := Webhook{
+		ID:                  e.ID,
+		CreatedAt:           e.CreatedAt,
+		UpdatedAt:           e.UpdatedAt,
+		URL:                 e.Url,
+		Events:              e.Events,
+		ConsecutiveFailures: e.ConsecutiveFailures,
+	}
+	if e.DisabledAt.Valid {
+		hook.DisabledAt = &e.DisabledAt.Time
+	}
+	return hook
+}
+
+func nullCode(n sql.NullInt32) *int32 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int32
+}
+
+// validateWebhookURL only allows https, except in dev where the test
+// receiver runs over plain http. Addresses that aren't public are refused
+// here when they're written out, and for names when delivering.
+func (cfg *apiConfig) validateWebhookURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "Invalid url"
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || cfg.platform != "dev") {
+		return "Webhook url must use https"
+	}
+	if cfg.platform != "dev" {
+		host := u.Hostname()
+		if addr, err := netip.ParseAddr(host); (err == nil && !webhook.PublicAddress(addr)) || strings.EqualFold(host, "localhost") {
+			return "Webhook url must be a public address"
+		}
+	}
+	return ""
+}
+
+// enqueueWebhook queues event for every active endpoint of owner subscribed
+// to it. The delivery worker picks the rows up, so the request that caused
+// the event never waits on an integrator's server. Failures are logged.
+func (cfg *apiConfig) enqueueWebhook(ctx context.Context, owner uuid.UUID, event string, data any) {
+	payload, err := json.Marshal(webhookPayload{
+		ID:        uuid.New(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		return
+	}
+	err = cfg.queries.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		Event:   event,
+		Payload: string(payload),
+		UserID:  owner,
+	})
+	if err != nil {
+		log.Printf("couldn't enqueue %s webhooks: %s", event, err)
+	}
+}
+
+// webhookLoop delivers due webhooks until the process exits. Claimed rows
+// are leased by pushing next_attempt_at forward, so several instances can
+// share the queue and a crashed worker's rows are retried after the lease.
+func (cfg *apiConfig) webhookLoop() {
+	ticker := time.NewTicker(webhookPollPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		for {
+			deliveries, err := cfg.queries.ClaimWebhookDeliveries(context.Background(), database.ClaimWebhookDeliveriesParams{
+				LeaseUntil: time.Now().Add(webhookLease),
+				BatchSize:  webhookBatchSize,
+			})
+			if err != nil {
+				log.Printf("couldn't claim webhook deliveries: %s", err)
+				break
+			}
+
+			var wg sync.WaitGroup
+			for _, d := range deliveries {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cfg.deliverWebhook(d)
+				}()
+			}
+			wg.Wait()
+
+			if len(deliveries) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// postWebhook sends one signed attempt and returns the response code, if
+// any, and a description of what went wrong, if anything.
+func (cfg *apiConfig) postWebhook(endpoint database.WebhookEndpoint, d database.WebhookDelivery) (sql.NullInt32, string) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequest("POST", endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return sql.NullInt32{}, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("Chirpy-Event", d.Event)
+	req.Header.Set("Chirpy-Delivery", d.ID.String())
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(endpoint.Secret, time.Now(), body))
+
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return sql.NullInt32{}, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	code := sql.NullInt32{Int32: int32(resp.StatusCode), Valid: true}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return code, fmt.Sprintf("endpoint responded %d", resp.StatusCode)
+	}
+	return code, ""
+}
+
+func (cfg *apiConfig) deliverWebhook(d database.WebhookDelivery) {
+	ctx := context.Background()
+	endpoint, err := cfg.queries.GetWebhookEndpoint(ctx, d.EndpointID)
+	if err != nil {
+		log.Printf("couldn't load webhook endpoint: %s", err)
+		return
+	}
+
+	// deliveries queued before the endpoint was disabled are abandoned
+	if endpoint.DisabledAt.Valid {
+		err = cfg.queries.MarkWebhookFailed(ctx, database.MarkWebhookFailedParams{
+			ID:               d.ID,
+			Status:           "cancelled",
+			LastResponseCode: d.LastResponseCode,
+			LastError:        "endpoint disabled",
+			NextAttemptAt:    d.NextAttemptAt,
+		})
+		if err != nil {
+			log.Printf("couldn't cancel webhook delivery: %s", err)
+		}
+		return
+	}
+
+	start := time.Now()
+	code, failure := cfg.postWebhook(endpoint, d)
+	if len(failure) > maxWebhookErrorSize {
+		failure = failure[:maxWebhookErrorSize]
+	}
+	err = cfg.queries.RecordWebhookAttempt(ctx, database.RecordWebhookAttemptParams{
+		DeliveryID:   d.ID,
+		ResponseCode: code,
+		Error:        failure,
+		DurationMs:   int32(time.Since(start).Milliseconds()),
+	})
+	if err != nil {
+		log.Printf("couldn't record webhook attempt: %s", err)
+	}
+
+	if failure == "" {
+		err = cfg.queries.MarkWebhookDelivered(ctx, database.MarkWebhookDeliveredParams{
+			ID:               d.ID,
+			LastResponseCode: code,
+		})
+		if err != nil {
+			log.Printf("couldn't mark webhook delivered: %s", err)
+		}
+		err = cfg.queries.ResetWebhookFailures(ctx, endpoint.ID)
+		if err != nil {
+			log.Printf("couldn't reset webhook failures: %s", err)
+		}
+		return
+	}
+
+	attempts := int(d.Attempts) + 1
+	status := "pending"
+	if attempts >= maxWebhookAttempts {
+		status = "failed"
+	}
+	err = cfg.queries.MarkWebhookFailed(ctx, database.MarkWebhookFailedParams{
+		ID:               d.ID,
+		Status:           status,
+		LastResponseCode: code,
+		LastError:        failure,
+		NextAttemptAt:    time.Now().Add(webhook.Backoff(attempts, webhookBackoffBase, webhookBackoffMax)),
+	})
+	if err != nil {
+		log.Printf("couldn't reschedule webhook delivery: %s", err)
+	}
+
+	endpoint, err = cfg.queries.RecordWebhookFailure(ctx, database.RecordWebhookFailureParams{
+		MaxFailures: maxWebhookFailures,
+		ID:          endpoint.ID,
+	})
+	if err != nil {
+		log.Printf("couldn't record webhook failure: %s", err)
+		return
+	}
+	if endpoint.DisabledAt.Valid && endpoint.ConsecutiveFailures == maxWebhookFailures {
+		log.Printf("disabled webhook %s after %d consecutive failures", endpoint.ID, endpoint.ConsecutiveFailures)
+	}
+}
+
+// ownedWebhook loads the {webhookID} endpoint if it belongs to the caller.
+// Other users' endpoints are reported as missing.
+func (cfg *apiConfig) ownedWebhook(w http.ResponseWriter, r *http.Request) (database.WebhookEndpoint, bool) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return database.WebhookEndpoint{}, false
+	}
+
+	id, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid webhook id")
+		return database.WebhookEndpoint{}, false
+	}
+
+	endpoint, err := cfg.queries.GetWebhookEndpoint(context.Background(), id)
+	if err != nil || endpoint.UserID != caller {
+		w.WriteHeader(404)
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+// createWebhook registers an endpoint. The signing secret is only ever
+// returned here.
+func (cfg *apiConfig) createWebhook(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if msg := cfg.validateWebhookURL(params.URL); msg != "" {
+		respondWithError(w, 400, msg)
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, 400, "At least one event is required")
+		return
+	}
+	events := []string{}
+	seen := map[string]bool{}
+	for _, e := range params.Events {
+		if !webhookEvents[e] {
+			respondWithError(w, 400, "Unknown event "+e)
+			return
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+
+	existing, err := cfg.queries.ListWebhookEndpoints(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list webhooks: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		respondWithError(w, 400, "Too many webhooks")
+		return
+	}
+
+	secret, err := webhook.MakeSecret()
+	if err != nil {
+		log.Printf("couldn't make webhook secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	endpoint, err := cfg.queries.CreateWebhookEndpoint(context.Background(), database.CreateWebhookEndpointParams{
+		UserID: caller,
+		Url:    params.URL,
+		Secret: secret,
+		Events: events,
+	})
+	if err != nil {
+		log.Printf("couldn't create webhook: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	hook := webhookFromDB(endpoint)
+	hook.Secret = endpoint.Secret
+	respondWithBody(w, 201, hook)
+}
+
+func (cfg *apiConfig) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	endpoints, err := cfg.queries.ListWebhookEndpoints(context.Background(), caller)
+	if err != nil {
+		log.Printf("couldn't list webhooks: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	hooks := []Webhook{}
+	for _, e := range endpoints {
+		hooks = append(hooks, webhookFromDB(e))
+	}
+	respondWithBody(w, 200, hooks)
+}
+
+func (cfg *apiConfig) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.DeleteWebhookEndpoint(context.Background(), endpoint.ID)
+	if err != nil {
+		log.Printf("couldn't delete webhook: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// enableWebhook turns an auto-disabled endpoint back on with a clean
+// failure count.
+func (cfg *apiConfig) enableWebhook(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.queries.EnableWebhookEndpoint(context.Background(), endpoint.ID)
+	if err != nil {
+		log.Printf("couldn't enable webhook: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// listWebhookDeliveries is the delivery log: the most recent deliveries to
+// an endpoint, each with every attempt and its response code.
+func (cfg *apiConfig) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultWebhookDeliveries
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = min(n, maxWebhookDeliveries)
+	}
+
+	rows, err := cfg.queries.ListWebhookDeliveries(context.Background(), database.ListWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		log.Printf("couldn't list webhook deliveries: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, d := range rows {
+		attempts, err := cfg.queries.ListWebhookAttempts(context.Background(), d.ID)
+		if err != nil {
+			log.Printf("couldn't list webhook attempts: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		delivery := WebhookDelivery{
+			ID:               d.ID,
+			CreatedAt:        d.CreatedAt,
+			Event:            d.Event,
+			Status:           d.Status,
+			Attempts:         d.Attempts,
+			LastResponseCode: nullCode(d.LastResponseCode),
+			LastError:        d.LastError,
+			Log:              []WebhookAttempt{},
+		}
+		if d.Status == "pending" {
+			delivery.NextAttemptAt = &d.NextAttemptAt
+		}
+		for _, a := range attempts {
+			delivery.Log = append(delivery.Log, WebhookAttempt{
+				CreatedAt:    a.CreatedAt,
+				ResponseCode: nullCode(a.ResponseCode),
+				Error:        a.Error,
+				DurationMs:   a.DurationMs,
+			})
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	respondWithBody(w, 200, deliveries)
+}
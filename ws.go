@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/tnaums/chirpy/internal/auth"
+	"github.com/tnaums/chirpy/internal/stream"
+)
+
+const (
+	wsMaxConnsPerUser = 5
+	wsSendBuffer      = 64
+	wsMaxMessageSize  = 4096
+	wsPingPeriod      = 30 * time.Second
+	wsPongWait        = 60 * time.Second
+	wsWriteWait       = 10 * time.Second
+
+	// application close codes, in the 4000-4999 private range
+	wsCloseTokenExpired = 4001
+	wsCloseSlowConsumer = 4008
+
+	wsChannelHome          = "home"
+	wsChannelNotifications = "notifications"
+	wsChannelThreadPrefix  = "thread:"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// mobile clients don't send Origin; browsers must be on our own origin
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://") == r.Host
+	},
+}
+
+// wsMessage is every frame sent in either direction. Clients send
+// subscribe, unsubscribe and auth; the server sends the rest.
+type wsMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Event   string `json:"event,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Message string `json:"message,omitempty"`
+	Dropped int    `json:"dropped,omitempty"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// wsHub tracks live connections per user, enforces the per-user limit and
+// routes notifications to the right sockets.
+type wsHub struct {
+	mu    sync.Mutex
+	conns map[uuid.UUID]map[*wsConn]struct{}
+}
+
+func newWSHub() *wsHub {
+	return &wsHub{conns: map[uuid.UUID]map[*wsConn]struct{}{}}
+}
+
+func (h *wsHub) count(userID uuid.UUID) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns[userID])
+}
+
+func (h *wsHub) add(c *wsConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.conns[c.userID]) >= wsMaxConnsPerUser {
+		return false
+	}
+	if h.conns[c.userID] == nil {
+		h.conns[c.userID] = map[*wsConn]struct{}{}
+	}
+	h.conns[c.userID][c] = struct{}{}
+	return true
+}
+
+func (h *wsHub) remove(c *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns[c.userID], c)
+	if len(h.conns[c.userID]) == 0 {
+		delete(h.conns, c.userID)
+	}
+}
+
+func (h *wsHub) deliverNotification(userID uuid.UUID, n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.conns[userID] {
+		if c.subscribed(wsChannelNotifications) {
+			c.enqueue(wsMessage{Type: "notification", Channel: wsChannelNotifications, Data: n}, false)
+		}
+	}
+}
+
+type wsConn struct {
+	cfg    *apiConfig
+	ws     *websocket.Conn
+	userID uuid.UUID
+	send   chan wsMessage
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	channels  map[string]bool
+	filter    authorFilter
+	dropped   int
+	expiresAt time.Time
+}
+
+func (c *wsConn) subscribed(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels[channel]
+}
+
+// enqueue queues msg for the writer without ever blocking. When the send
+// buffer is full a droppable message (timeline traffic) is discarded and
+// counted, while anything else (notifications, control replies) closes the
+// connection so the client reconnects and resyncs instead of missing it.
+func (c *wsConn) enqueue(msg wsMessage, droppable bool) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		if droppable {
+			c.mu.Lock()
+			c.dropped++
+			c.mu.Unlock()
+			return
+		}
+		c.close(wsCloseSlowConsumer, "send buffer full")
+	}
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		msg := websocket.FormatCloseMessage(code, reason)
+		c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// refresh reloads who the user follows and who is hidden from them.
+func (c *wsConn) refresh() {
+	filter, err := c.cfg.loadAuthorFilter(context.Background(), c.userID)
+	if err != nil {
+		log.Printf("couldn't load author filter: %s", err)
+		return
+	}
+	c.mu.Lock()
+	c.filter = filter
+	c.mu.Unlock()
+}
+
+// route decides which subscribed channels, if any, a chirp event belongs to.
+func (c *wsConn) route(e stream.Event) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.filter.allows(e) {
+		return nil
+	}
+	var channels []string
+	if c.channels[wsChannelHome] && (e.AuthorID == c.userID || c.filter.following[e.AuthorID]) {
+		channels = append(channels, wsChannelHome)
+	}
+	if thread := wsChannelThreadPrefix + e.ChirpID.String(); c.channels[thread] {
+		channels = append(channels, thread)
+	}
+	return channels
+}
+
+func (c *wsConn) handle(msg wsMessage) {
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		valid := msg.Channel == wsChannelHome || msg.Channel == wsChannelNotifications
+		if id, ok := strings.CutPrefix(msg.Channel, wsChannelThreadPrefix); ok {
+			_, err := uuid.Parse(id)
+			valid = err == nil
+		}
+		if !valid {
+			c.enqueue(wsMessage{Type: "error", Message: "unknown channel " + msg.Channel}, false)
+			return
+		}
+		c.mu.Lock()
+		c.channels[msg.Channel] = msg.Type == "subscribe"
+		c.mu.Unlock()
+		c.enqueue(wsMessage{Type: msg.Type + "d", Channel: msg.Channel}, false)
+
+	// clients send a fresh access token before the current one expires
+	case "auth":
+		claims, err := auth.ValidateJWT(msg.Token, c.cfg.jwtKeys)
+		if err == nil {
+			err = c.cfg.checkAccessToken(context.Background(), claims)
+		}
+		if err != nil || claims.UserID != c.userID {
+			c.enqueue(wsMessage{Type: "error", Message: "invalid token"}, false)
+			return
+		}
+		c.mu.Lock()
+		c.expiresAt = claims.ExpiresAt
+		c.mu.Unlock()
+		c.enqueue(wsMessage{Type: "authenticated"}, false)
+
+	default:
+		c.enqueue(wsMessage{Type: "error", Message: "unknown message type " + msg.Type}, false)
+	}
+}
+
+func (c *wsConn) readPump() {
+	defer c.close(websocket.CloseNormalClosure, "")
+	c.ws.SetReadLimit(wsMaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		var msg wsMessage
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		c.handle(msg)
+	}
+}
+
+func (c *wsConn) writePump() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		c.mu.Lock()
+		untilExpiry := time.Until(c.expiresAt)
+		c.mu.Unlock()
+		expiry := time.NewTimer(untilExpiry)
+
+		select {
+		case <-c.done:
+			expiry.Stop()
+			return
+		case msg := <-c.send:
+			expiry.Stop()
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.ws.WriteJSON(msg); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			expiry.Stop()
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+			c.mu.Lock()
+			dropped := c.dropped
+			c.dropped = 0
+			c.mu.Unlock()
+			if dropped > 0 {
+				c.enqueue(wsMessage{Type: "lagged", Dropped: dropped}, true)
+			}
+			c.refresh()
+		case <-expiry.C:
+			// the loop re-arms the timer if an auth message extended it
+			c.mu.Lock()
+			expired := !time.Now().Before(c.expiresAt)
+			c.mu.Unlock()
+			if expired {
+				c.close(wsCloseTokenExpired, "token expired")
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) eventPump() {
+	sub := c.cfg.broker.Subscribe(nil, wsSendBuffer)
+	defer func() { c.cfg.broker.Unsubscribe(sub) }()
+	for {
+		select {
+		case <-c.done:
+			return
+		case e, ok := <-sub.C:
+			// the broker dropped us for lagging; note it and catch up live
+			if !ok {
+				c.enqueue(wsMessage{Type: "lagged"}, true)
+				sub = c.cfg.broker.Subscribe(nil, wsSendBuffer)
+				continue
+			}
+			for _, channel := range c.route(e) {
+				c.enqueue(wsMessage{Type: "event", Channel: channel, Event: string(e.Type), Data: chirpEventData(e)}, true)
+			}
+		}
+	}
+}
+
+// serveWS upgrades to a WebSocket carrying the home timeline, notifications
+// and chirp threads. The access token comes from the Authorization header
+// or, for browsers that can't set headers, the access_token query value.
+func (cfg *apiConfig) serveWS(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("access_token")
+	if token == "" {
+		token, _ = auth.GetBearerToken(r.Header)
+	}
+	claims, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err == nil {
+		err = cfg.checkAccessToken(context.Background(), claims)
+	}
+	if err != nil {
+		log.Printf("token is invalid: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+	userID, expiresAt := claims.UserID, claims.ExpiresAt
+
+	if cfg.wsHub.count(userID) >= wsMaxConnsPerUser {
+		respondWithError(w, 429, "Too many connections")
+		return
+	}
+
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("couldn't upgrade to websocket: %s", err)
+		return
+	}
+	c := &wsConn{
+		cfg:       cfg,
+		ws:        ws,
+		userID:    userID,
+		send:      make(chan wsMessage, wsSendBuffer),
+		done:      make(chan struct{}),
+		channels:  map[string]bool{},
+		expiresAt: expiresAt,
+	}
+
+	// another connection may have slipped in since the check above
+	if !cfg.wsHub.add(c) {
+		c.close(websocket.ClosePolicyViolation, "too many connections")
+		return
+	}
+	defer cfg.wsHub.remove(c)
+	c.refresh()
+
+	go c.writePump()
+	go c.eventPump()
+	c.readPump()
+}
+
+func (cfg *apiConfig) handleNotificationEvent(payload string) {
+	var e notificationEvent
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		log.Printf("couldn't decode notification event: %s", err)
+		return
+	}
+	cfg.wsHub.deliverNotification(e.UserID, e.Notification)
+}